@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errNoACLFile is returned by ACL LOAD and ACL SAVE when no aclfile is set.
+var errNoACLFile = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// aclCommand handles the ACL command and its subcommands:
+//   - ACL SETUSER username [rule ...]: creates or modifies a user
+//   - ACL GETUSER username: describes a user's flags, passwords, commands, keys and channels
+//   - ACL DELUSER username [username ...]: deletes users and disconnects their clients
+//   - ACL LIST: every user as a rule list
+//   - ACL USERS: every user name
+//   - ACL WHOAMI: the user of the connection
+//   - ACL CAT [category]: the categories, or the commands in a category
+//   - ACL DRYRUN username command [arg ...]: whether the user could run the command
+//   - ACL LOAD / ACL SAVE: reloads or stores the users in the aclfile
+//   - ACL LOG [count|RESET]: the recent denials, see aclLogCommand
+func aclCommand(c *client, args []string) {
+	subCommand := strings.ToUpper(args[0])
+
+	switch {
+	case subCommand == "SETUSER" && len(args) >= 2:
+		aclSetuser(c, args[1], args[2:])
+	case subCommand == "GETUSER" && len(args) == 2:
+		aclGetuser(c, args[1])
+	case subCommand == "DELUSER" && len(args) >= 2:
+		aclDeluser(c, args[1:])
+	case subCommand == "LIST" && len(args) == 1:
+		names := sortedACLUserNames()
+		c.out.writeArrayLen(len(names))
+		for _, name := range names {
+			c.out.writeBulkString(aclUsers[name].describe())
+		}
+	case subCommand == "USERS" && len(args) == 1:
+		c.out.writeBulkStrings(sortedACLUserNames()...)
+	case subCommand == "WHOAMI" && len(args) == 1:
+		c.out.writeBulkString(c.user)
+	case subCommand == "CAT" && len(args) <= 2:
+		aclCat(c, args[1:])
+	case subCommand == "DRYRUN" && len(args) >= 3:
+		aclDryrun(c, args[1], args[2], args[3:])
+	case subCommand == "LOG" && len(args) <= 2:
+		aclLogCommand(c, args[1:])
+	case subCommand == "LOAD" && len(args) == 1:
+		if err := loadACLFile(); err != nil {
+			c.out.writeError("ERR " + err.Error())
+			return
+		}
+		c.out.writeSimpleString("OK")
+	case subCommand == "SAVE" && len(args) == 1:
+		if err := saveACLFile(); err != nil {
+			c.out.writeError("ERR " + err.Error())
+			return
+		}
+		c.out.writeSimpleString("OK")
+	default:
+		c.out.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[0]))
+	}
+}
+
+func sortedACLUserNames() []string {
+	names := make([]string, 0, len(aclUsers))
+	for name := range aclUsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aclSetuser applies rules to the user called name, creating it if needed. The
+// rules are applied to a copy, so the user is left untouched if one fails.
+func aclSetuser(c *client, name string, rules []string) {
+	u, ok := aclUsers[name]
+	if !ok {
+		u = newACLUser(name)
+	}
+	u = u.clone()
+
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			c.out.writeError(fmt.Sprintf("ERR Error in ACL SETUSER modifier '%s': %s", rule, err))
+			return
+		}
+	}
+
+	aclUsers[name] = u
+	c.out.writeSimpleString("OK")
+}
+
+// aclGetuser writes the description of a user as a map, or a null reply if the
+// user does not exist.
+func aclGetuser(c *client, name string) {
+	u, ok := aclUsers[name]
+	if !ok {
+		c.out.writeNull()
+		return
+	}
+
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+
+	c.out.writeMapLen(6)
+	c.out.writeBulkString("flags")
+	c.out.writeSetLen(len(flags))
+	for _, f := range flags {
+		c.out.writeBulkString(f)
+	}
+	c.out.writeBulkString("passwords")
+	c.out.writeBulkStrings(u.sortedPasswords()...)
+	c.out.writeBulkString("commands")
+	c.out.writeBulkString(u.commandRules())
+	c.out.writeBulkString("keys")
+	c.out.writeBulkString(u.keyRules())
+	c.out.writeBulkString("channels")
+	c.out.writeBulkString(u.channelRules())
+	c.out.writeBulkString("selectors")
+	c.out.writeArrayLen(0)
+}
+
+// aclDeluser deletes users and disconnects the clients authenticated as them.
+// The default user cannot be deleted.
+func aclDeluser(c *client, names []string) {
+	for _, name := range names {
+		if name == "default" {
+			c.out.writeError("ERR The 'default' user cannot be removed")
+			return
+		}
+	}
+
+	deleted := 0
+	for _, name := range names {
+		if _, ok := aclUsers[name]; !ok {
+			continue
+		}
+		delete(aclUsers, name)
+		deleted++
+	}
+
+	disconnectClientsWithoutUser()
+	c.out.writeInteger(int64(deleted))
+}
+
+// disconnectClientsWithoutUser closes the connection of every client whose user
+// no longer exists. The connection's goroutine releases the client.
+func disconnectClientsWithoutUser() {
+	clients.Range(func(key, value interface{}) bool {
+		cl := value.(*client)
+		if _, ok := aclUsers[cl.user]; !ok {
+			cl.conn.Close()
+		}
+		return true
+	})
+}
+
+// aclCat lists the categories, or the commands of one category.
+func aclCat(c *client, args []string) {
+	if len(args) == 0 {
+		names := make([]string, 0, len(aclCategoryNames))
+		for _, cat := range aclCategoryNames {
+			names = append(names, cat.name)
+		}
+		c.out.writeBulkStrings(names...)
+		return
+	}
+
+	category, ok := lookupCategory(args[0])
+	if !ok {
+		c.out.writeError(fmt.Sprintf("ERR Unknown category '%s'", args[0]))
+		return
+	}
+
+	var names []string
+	for name, cmd := range registry {
+		if cmd.categories&category != 0 {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	c.out.writeBulkStrings(names...)
+}
+
+// aclDryrun checks whether user could run command with args, without running
+// it. The reply is OK, or a bulk string explaining the denial.
+func aclDryrun(c *client, user, command string, args []string) {
+	if _, ok := aclUsers[user]; !ok {
+		c.out.writeError(fmt.Sprintf("ERR User '%s' not found", user))
+		return
+	}
+
+	name := strings.ToUpper(command)
+	cmd, ok := registry[name]
+	if !ok {
+		c.out.writeError(fmt.Sprintf("ERR Command '%s' not found", command))
+		return
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs != variadic && len(args) > cmd.maxArgs) {
+		c.out.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+		return
+	}
+
+	if denial := aclCheckCommand(user, name, cmd, args); denial.reason != aclAllowed {
+		c.out.writeBulkString(denial.detail())
+		return
+	}
+	c.out.writeSimpleString("OK")
+}
+
+// loadACLFile replaces the users with the ones in the aclfile: one
+// "user <name> [rule ...]" line per user. Blank lines and lines starting with
+// '#' are ignored. Nothing changes if any line is invalid. The default user is
+// recreated with its initial rules if the file does not define it.
+func loadACLFile() error {
+	if config.aclfile == "" {
+		return errNoACLFile
+	}
+
+	file, err := os.Open(config.aclfile)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %v", config.aclfile, err)
+	}
+	defer file.Close()
+
+	users := map[string]*aclUser{}
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields, ok := splitInlineArgs(line)
+		if !ok || len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", config.aclfile, lineno)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", config.aclfile, lineno, fields[1])
+		}
+
+		u := newACLUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %s. ", config.aclfile, lineno, err)
+			}
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error loading ACLs, reading file '%s': %v", config.aclfile, err)
+	}
+
+	if _, ok := users["default"]; !ok {
+		users["default"] = newDefaultUser()
+	}
+
+	aclUsers = users
+	disconnectClientsWithoutUser()
+	return nil
+}
+
+// saveACLFile writes every user to the aclfile. The file is written next to the
+// old one and renamed over it, so a failed save never leaves it truncated.
+func saveACLFile() error {
+	if config.aclfile == "" {
+		return errNoACLFile
+	}
+
+	var b strings.Builder
+	for _, name := range sortedACLUserNames() {
+		b.WriteString(aclUsers[name].describe())
+		b.WriteString("\n")
+	}
+
+	if err := writeFileAtomic(config.aclfile, b.String()); err != nil {
+		fmt.Println("Error saving ACL file:", err)
+		return fmt.Errorf("There was an error trying to save the ACLs. Please check the server logs for more information")
+	}
+
+	return nil
+}
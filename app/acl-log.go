@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aclLogGroupingWindow is how long a denial keeps being counted in the entry of
+// a previous, identical denial instead of adding a new entry.
+const aclLogGroupingWindow = 60 * time.Second
+
+// aclLogEntry records denials that share their reason, context, object and
+// user, as reported by ACL LOG.
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string // "command", "key", "channel" or "auth"
+	context    string // "toplevel" or "multi"
+	object     string // Denied command, key or channel, "AUTH" for authentication failures
+	username   string
+	clientInfo string // The client as CLIENT INFO describes it
+	created    time.Time
+	updated    time.Time
+}
+
+// aclLog holds the most recent entries first. It is guarded by commandLock and
+// holds at most config.acllogMaxLen entries.
+var (
+	aclLog       []*aclLogEntry
+	aclLogNextID int64
+)
+
+// addACLLogEntry records a denial. A denial identical to an entry updated less
+// than aclLogGroupingWindow ago increments that entry's count and moves it to
+// the front instead.
+func addACLLogEntry(c *client, reason, context, object, username string) {
+	now := time.Now()
+
+	for i, e := range aclLog {
+		if e.reason != reason || e.context != context || e.object != object || e.username != username ||
+			now.Sub(e.updated) >= aclLogGroupingWindow {
+			continue
+		}
+
+		e.count++
+		e.updated = now
+		e.clientInfo = c.info()
+		copy(aclLog[1:i+1], aclLog[:i])
+		aclLog[0] = e
+		return
+	}
+
+	entry := &aclLogEntry{
+		id:         aclLogNextID,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: c.info(),
+		created:    now,
+		updated:    now,
+	}
+	aclLogNextID++
+
+	aclLog = append([]*aclLogEntry{entry}, aclLog...)
+	trimACLLog()
+}
+
+// trimACLLog drops the oldest entries beyond acllog-max-len.
+func trimACLLog() {
+	if len(aclLog) > config.acllogMaxLen {
+		aclLog = aclLog[:config.acllogMaxLen]
+	}
+}
+
+// logACLDenial records a command rejected by ACL rules.
+func logACLDenial(c *client, denial aclDenial, context string) {
+	reason := "command"
+	switch denial.reason {
+	case aclDeniedKey:
+		reason = "key"
+	case aclDeniedChannel:
+		reason = "channel"
+	}
+
+	addACLLogEntry(c, reason, context, denial.object, denial.user)
+}
+
+// aclLogCommand handles ACL LOG [count|RESET]: it lists the most recent
+// entries (10 by default), newest first, or clears the log.
+func aclLogCommand(c *client, args []string) {
+	count := 10
+	if len(args) == 1 {
+		if strings.EqualFold(args[0], "RESET") {
+			aclLog = nil
+			c.out.writeSimpleString("OK")
+			return
+		}
+
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			c.out.writeError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	entries := aclLog[:min(count, len(aclLog))]
+	now := time.Now()
+
+	c.out.writeArrayLen(len(entries))
+	for _, e := range entries {
+		c.out.writeMapLen(10)
+		c.out.writeBulkString("count")
+		c.out.writeInteger(int64(e.count))
+		c.out.writeBulkString("reason")
+		c.out.writeBulkString(e.reason)
+		c.out.writeBulkString("context")
+		c.out.writeBulkString(e.context)
+		c.out.writeBulkString("object")
+		c.out.writeBulkString(e.object)
+		c.out.writeBulkString("username")
+		c.out.writeBulkString(e.username)
+		c.out.writeBulkString("age-seconds")
+		c.out.writeDouble(now.Sub(e.created).Seconds())
+		c.out.writeBulkString("client-info")
+		c.out.writeBulkString(e.clientInfo)
+		c.out.writeBulkString("entry-id")
+		c.out.writeInteger(e.id)
+		c.out.writeBulkString("timestamp-created")
+		c.out.writeInteger(e.created.UnixMilli())
+		c.out.writeBulkString("timestamp-last-updated")
+		c.out.writeInteger(e.updated.UnixMilli())
+	}
+}
+
+// aclLogContext returns the context of a denial for client c.
+func aclLogContext(c *client) string {
+	if c.hasFlag(flagMulti) {
+		return "multi"
+	}
+	return "toplevel"
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// aclCategory is a bit set of ACL command categories, such as @read or @admin.
+type aclCategory uint32
+
+const (
+	catKeyspace aclCategory = 1 << iota
+	catRead
+	catWrite
+	catString
+	catHash
+	catSet
+	catSortedSet
+	catPubSub
+	catAdmin
+	catFast
+	catSlow
+	catDangerous
+	catConnection
+	catTransaction
+)
+
+// aclCategoryNames maps the category names used in ACL rules to their bit, in
+// the order ACL CAT lists them.
+var aclCategoryNames = []struct {
+	name     string
+	category aclCategory
+}{
+	{"keyspace", catKeyspace},
+	{"read", catRead},
+	{"write", catWrite},
+	{"string", catString},
+	{"hash", catHash},
+	{"set", catSet},
+	{"sortedset", catSortedSet},
+	{"pubsub", catPubSub},
+	{"admin", catAdmin},
+	{"fast", catFast},
+	{"slow", catSlow},
+	{"dangerous", catDangerous},
+	{"connection", catConnection},
+	{"transaction", catTransaction},
+}
+
+// lookupCategory returns the category named name, without its '@'.
+func lookupCategory(name string) (aclCategory, bool) {
+	for _, c := range aclCategoryNames {
+		if strings.EqualFold(c.name, name) {
+			return c.category, true
+		}
+	}
+	return 0, false
+}
+
+// keyPattern is a key pattern of an ACL user with the access it grants.
+type keyPattern struct {
+	pattern string
+	access  keyAccess
+}
+
+// aclUser is a user commands can be run as. Users are guarded by commandLock.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool                // Any password is accepted
+	passwords map[string]struct{} // SHA-256 hashes, hex encoded
+
+	allCommands bool            // Base of the command rules: +@all or -@all
+	allowed     map[string]bool // Commands ("GET") and subcommands ("CONFIG|GET") allowed or denied on top of the base
+	rules       []string        // Command rules applied on top of the base, as given, to describe the user
+
+	keys     []keyPattern
+	channels []string
+}
+
+// aclUsers holds the users by name. It always contains "default", the user
+// connections are authenticated as when they connect.
+var aclUsers map[string]*aclUser
+
+func init() {
+	resetACLUsers()
+}
+
+// resetACLUsers replaces every user with the initial default user, which is
+// enabled, needs no password and can run everything.
+func resetACLUsers() {
+	aclUsers = map[string]*aclUser{"default": newDefaultUser()}
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:      name,
+		passwords: map[string]struct{}{},
+		allowed:   map[string]bool{},
+	}
+}
+
+func newDefaultUser() *aclUser {
+	u := newACLUser("default")
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		u.applyRule(rule)
+	}
+	return u
+}
+
+// hashPassword returns the hex encoded SHA-256 hash of password, which is how
+// passwords are stored and shown.
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// clone returns a deep copy of u, so that ACL SETUSER can apply its rules on a
+// copy and only keep the result if they are all valid.
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = map[string]struct{}{}
+	for h := range u.passwords {
+		c.passwords[h] = struct{}{}
+	}
+	c.allowed = map[string]bool{}
+	for name, ok := range u.allowed {
+		c.allowed[name] = ok
+	}
+	c.rules = append([]string(nil), u.rules...)
+	c.keys = append([]keyPattern(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	return &c
+}
+
+// applyRule changes u according to one ACL SETUSER rule.
+//
+// Returns:
+//   - nil on success
+//   - An error describing why the rule is invalid
+func (u *aclUser) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+
+	switch {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass = true
+		u.passwords = map[string]struct{}{}
+	case lower == "resetpass":
+		u.nopass = false
+		u.passwords = map[string]struct{}{}
+	case strings.HasPrefix(rule, ">"):
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case strings.HasPrefix(rule, "<"):
+		delete(u.passwords, hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		hash := rule[1:]
+		if !isPasswordHash(hash) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.passwords[hash] = struct{}{}
+		u.nopass = false
+	case strings.HasPrefix(rule, "!"):
+		hash := rule[1:]
+		if !isPasswordHash(hash) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		delete(u.passwords, hash)
+
+	case lower == "allkeys":
+		u.keys = []keyPattern{{"*", keyReadWrite}}
+	case lower == "resetkeys":
+		u.keys = nil
+	case strings.HasPrefix(rule, "~"):
+		u.keys = append(u.keys, keyPattern{rule[1:], keyReadWrite})
+	case strings.HasPrefix(rule, "%"):
+		flags, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok {
+			return fmt.Errorf("Syntax error")
+		}
+		var access keyAccess
+		for _, f := range strings.ToUpper(flags) {
+			switch f {
+			case 'R':
+				access |= keyRead
+			case 'W':
+				access |= keyWrite
+			default:
+				return fmt.Errorf("Syntax error")
+			}
+		}
+		if access == 0 {
+			return fmt.Errorf("Syntax error")
+		}
+		u.keys = append(u.keys, keyPattern{pattern, access})
+
+	case lower == "allchannels":
+		u.channels = []string{"*"}
+	case lower == "resetchannels":
+		u.channels = nil
+	case strings.HasPrefix(rule, "&"):
+		u.channels = append(u.channels, rule[1:])
+
+	case lower == "allcommands" || lower == "+@all":
+		u.setAllCommands(true)
+	case lower == "nocommands" || lower == "-@all":
+		u.setAllCommands(false)
+	case strings.HasPrefix(rule, "+") || strings.HasPrefix(rule, "-"):
+		if err := u.applyCommandRule(rule[0] == '+', rule[1:]); err != nil {
+			return err
+		}
+		u.rules = append(u.rules, lower)
+
+	case lower == "reset":
+		*u = *newACLUser(u.name)
+	default:
+		return fmt.Errorf("Syntax error")
+	}
+
+	return nil
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9') && !('a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// setAllCommands makes u allow or deny every command and forgets the previous
+// command rules.
+func (u *aclUser) setAllCommands(allow bool) {
+	u.allCommands = allow
+	u.allowed = map[string]bool{}
+	u.rules = nil
+}
+
+// applyCommandRule allows or denies a command ("get"), a subcommand
+// ("config|get") or a category ("@read").
+func (u *aclUser) applyCommandRule(allow bool, target string) error {
+	if strings.HasPrefix(target, "@") {
+		category, ok := lookupCategory(target[1:])
+		if !ok {
+			return fmt.Errorf("Unknown command or category name in ACL")
+		}
+		for name, cmd := range registry {
+			if cmd.categories&category != 0 {
+				u.setCommand(name, allow)
+			}
+		}
+		return nil
+	}
+
+	name, sub, hasSub := strings.Cut(strings.ToUpper(target), "|")
+	if _, ok := registry[name]; !ok {
+		return fmt.Errorf("Unknown command or category name in ACL")
+	}
+	if hasSub {
+		if sub == "" || strings.Contains(sub, "|") {
+			return fmt.Errorf("Syntax error")
+		}
+		u.allowed[name+"|"+sub] = allow
+		return nil
+	}
+
+	u.setCommand(name, allow)
+	return nil
+}
+
+// setCommand allows or denies name along with all its subcommands.
+func (u *aclUser) setCommand(name string, allow bool) {
+	for key := range u.allowed {
+		if strings.HasPrefix(key, name+"|") {
+			delete(u.allowed, key)
+		}
+	}
+	u.allowed[name] = allow
+}
+
+// canRun reports whether u may run the command name with args. Subcommand
+// rules take precedence over the rule of their command.
+func (u *aclUser) canRun(name string, args []string) bool {
+	if len(args) > 0 {
+		if allow, ok := u.allowed[name+"|"+strings.ToUpper(args[0])]; ok {
+			return allow
+		}
+	}
+	if allow, ok := u.allowed[name]; ok {
+		return allow
+	}
+	return u.allCommands
+}
+
+// canAccessKey reports whether one of u's key patterns grants access to key.
+func (u *aclUser) canAccessKey(key string, access keyAccess) bool {
+	for _, p := range u.keys {
+		if p.access&access == access && stringMatch(p.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel reports whether u may publish or subscribe to channel. With
+// pattern set, channel is a PSUBSCRIBE pattern, which must be one of u's
+// channel patterns literally.
+func (u *aclUser) canAccessChannel(channel string, pattern bool) bool {
+	for _, p := range u.channels {
+		if p == "*" || (pattern && p == channel) || (!pattern && stringMatch(p, channel, false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandRules describes the command rules of u, e.g. "+@all -keys".
+func (u *aclUser) commandRules() string {
+	base := "-@all"
+	if u.allCommands {
+		base = "+@all"
+	}
+	return strings.Join(append([]string{base}, u.rules...), " ")
+}
+
+// keyRules describes the key patterns of u, e.g. "~cache:* %R~config:*".
+func (u *aclUser) keyRules() string {
+	var rules []string
+	for _, p := range u.keys {
+		switch p.access {
+		case keyRead:
+			rules = append(rules, "%R~"+p.pattern)
+		case keyWrite:
+			rules = append(rules, "%W~"+p.pattern)
+		default:
+			rules = append(rules, "~"+p.pattern)
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// channelRules describes the channel patterns of u, e.g. "&news:*".
+func (u *aclUser) channelRules() string {
+	var rules []string
+	for _, p := range u.channels {
+		rules = append(rules, "&"+p)
+	}
+	return strings.Join(rules, " ")
+}
+
+// sortedPasswords returns the password hashes of u in a stable order.
+func (u *aclUser) sortedPasswords() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for h := range u.passwords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// describe formats u as an ACL rule list, as ACL LIST shows it and the aclfile
+// stores it, e.g. "user default on nopass ~* &* +@all".
+func (u *aclUser) describe() string {
+	fields := []string{"user", u.name}
+	if u.enabled {
+		fields = append(fields, "on")
+	} else {
+		fields = append(fields, "off")
+	}
+	if u.nopass {
+		fields = append(fields, "nopass")
+	}
+	for _, h := range u.sortedPasswords() {
+		fields = append(fields, "#"+h)
+	}
+	for _, rules := range []string{u.keyRules(), u.channelRules()} {
+		if rules != "" {
+			fields = append(fields, rules)
+		}
+	}
+	fields = append(fields, u.commandRules())
+
+	return strings.Join(fields, " ")
+}
+
+// aclDenyReason tells why ACL rules rejected a command.
+type aclDenyReason int
+
+const (
+	aclAllowed aclDenyReason = iota
+	aclDeniedCommand
+	aclDeniedKey
+	aclDeniedChannel
+)
+
+// aclDenial describes a command rejected by ACL rules: which user ran it and
+// the command, key or channel that was denied.
+type aclDenial struct {
+	reason aclDenyReason
+	user   string
+	object string
+}
+
+// message returns the error sent to the client, without the NOPERM prefix.
+func (d aclDenial) message() string {
+	switch d.reason {
+	case aclDeniedKey:
+		return "No permissions to access a key"
+	case aclDeniedChannel:
+		return "No permissions to access a channel"
+	default:
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command", d.user, d.object)
+	}
+}
+
+// detail explains the denial as ACL DRYRUN does.
+func (d aclDenial) detail() string {
+	switch d.reason {
+	case aclDeniedKey:
+		return fmt.Sprintf("User %s has no permissions to access the '%s' key", d.user, d.object)
+	case aclDeniedChannel:
+		return fmt.Sprintf("User %s has no permissions to access the '%s' channel", d.user, d.object)
+	default:
+		return d.message()
+	}
+}
+
+// aclCheckCommand checks the ACL rules of the user named userName against a
+// command: the command itself, then the keys and channels it accesses. AUTH,
+// HELLO and QUIT are always allowed, like they are before authenticating.
+func aclCheckCommand(userName, name string, cmd commandEntry, args []string) aclDenial {
+	if isAuthExempt(name) {
+		return aclDenial{}
+	}
+
+	u, ok := aclUsers[userName]
+	if !ok {
+		return aclDenial{reason: aclDeniedCommand, user: userName, object: strings.ToLower(name)}
+	}
+
+	if !u.canRun(name, args) {
+		object := strings.ToLower(name)
+		if containerCommands[name] && len(args) > 0 {
+			object += "|" + strings.ToLower(args[0])
+		}
+		return aclDenial{reason: aclDeniedCommand, user: u.name, object: object}
+	}
+
+	for _, spec := range cmd.keys {
+		last := spec.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := spec.first; i <= last && i < len(args); i += spec.step {
+			if !u.canAccessKey(args[i], spec.access) {
+				return aclDenial{reason: aclDeniedKey, user: u.name, object: args[i]}
+			}
+		}
+	}
+
+	channels, patterns := commandChannels(name, args)
+	for _, channel := range channels {
+		if !u.canAccessChannel(channel, patterns) {
+			return aclDenial{reason: aclDeniedChannel, user: u.name, object: channel}
+		}
+	}
+
+	return aclDenial{}
+}
+
+// commandChannels returns the pub/sub channels a command publishes or
+// subscribes to, and whether they are PSUBSCRIBE patterns.
+func commandChannels(name string, args []string) (channels []string, patterns bool) {
+	switch name {
+	case "PUBLISH", "SPUBLISH":
+		return args[:1], false
+	case "SUBSCRIBE", "SSUBSCRIBE":
+		return args, false
+	case "PSUBSCRIBE":
+		return args, true
+	default:
+		return nil, false
+	}
+}
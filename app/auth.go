@@ -0,0 +1,91 @@
+package main
+
+import "crypto/subtle"
+
+// isAuthExempt reports whether a command may run on a connection that has not
+// authenticated yet.
+func isAuthExempt(name string) bool {
+	switch name {
+	case "AUTH", "HELLO", "QUIT":
+		return true
+	default:
+		return false
+	}
+}
+
+// checkPassword reports whether password is valid for the ACL user named user.
+// Disabled users never authenticate, and nopass users accept any password.
+func checkPassword(user, password string) bool {
+	u, ok := aclUsers[user]
+	if !ok || !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+
+	hash := hashPassword(password)
+	for h := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultUserNeedsAuth reports whether new connections must authenticate
+// before running commands, i.e. whether the default user has a password or is
+// disabled.
+func defaultUserNeedsAuth() bool {
+	u := aclUsers["default"]
+	return !u.enabled || !u.nopass
+}
+
+// setRequirepass sets the password of the default user, replacing its other
+// passwords. An empty password lets the default user in without one.
+func setRequirepass(password string) {
+	config.requirepass = password
+
+	u := aclUsers["default"]
+	if password == "" {
+		u.applyRule("nopass")
+		return
+	}
+	u.applyRule("resetpass")
+	u.applyRule(">" + password)
+}
+
+// authenticate checks the credentials and, if they are valid, authenticates
+// the connection as user.
+//
+// Returns:
+//   - true if the connection is now authenticated
+//   - false and writes the WRONGPASS error otherwise
+func authenticate(c *client, user, password string) bool {
+	if !checkPassword(user, password) {
+		addACLLogEntry(c, "auth", aclLogContext(c), "AUTH", user)
+		c.out.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+
+	c.user = user
+	c.authenticated = true
+	return true
+}
+
+// authCommand handles the AUTH [username] password command. The legacy form
+// with only a password authenticates as the default user and fails when the
+// default user has no password.
+func authCommand(c *client, args []string) {
+	user, password := "default", args[0]
+	if len(args) == 2 {
+		user, password = args[0], args[1]
+	} else if aclUsers["default"].nopass {
+		c.out.writeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+
+	if authenticate(c, user, password) {
+		c.out.writeSimpleString("OK")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientFlag is a bit set describing the state of a connection.
+type clientFlag uint32
+
+const (
+	flagMulti           clientFlag = 1 << iota // Inside MULTI, commands are being queued
+	flagCloseAfterReply                        // Close the connection once the pending reply is written
+	flagDirtyExec                              // A command failed to queue, EXEC must abort
+)
+
+// queuedCommand is a command received between MULTI and EXEC.
+type queuedCommand struct {
+	name    string
+	args    []string
+	handler CommandHandler
+}
+
+// client holds the per-connection state command handlers work with. It is
+// created by handleConnection when a connection is accepted and released when
+// that connection ends.
+type client struct {
+	id        int64
+	conn      net.Conn
+	createdAt time.Time
+
+	db            *database // Database selected with SELECT, databases[0] by default
+	user          string    // User the connection is authenticated as
+	authenticated bool      // False until AUTH succeeds if the default user needed a password when the client connected
+	flags         clientFlag
+	name          string // Name set with CLIENT SETNAME
+
+	queue   []queuedCommand // Commands queued after MULTI
+	watched []watchedKey    // Keys watched with WATCH
+
+	channels      map[string]struct{} // Channels subscribed to with SUBSCRIBE
+	patterns      map[string]struct{} // Patterns subscribed to with PSUBSCRIBE
+	shardChannels map[string]struct{} // Shard channels subscribed to with SSUBSCRIBE
+
+	lastInteraction time.Time
+	lastCommand     string
+
+	out *replyWriter // Buffered replies, also written to by other clients' PUBLISH
+}
+
+var (
+	nextClientID atomic.Int64
+	clients      sync.Map // Connected clients by id
+)
+
+// newClient creates the state of a newly accepted connection and registers it
+// in the clients list.
+func newClient(conn net.Conn) *client {
+	now := time.Now()
+	c := &client{
+		id:              nextClientID.Add(1),
+		conn:            conn,
+		createdAt:       now,
+		db:              databases[0],
+		user:            "default",
+		lastInteraction: now,
+		channels:        map[string]struct{}{},
+		patterns:        map[string]struct{}{},
+		shardChannels:   map[string]struct{}{},
+	}
+
+	c.out = newReplyWriter(conn, c.outputBufferClass, func() {
+		fmt.Printf("DEBUG: Client id=%d closed for overcoming of output buffer limits\n", c.id)
+		serverStats.outputBufferLimitDisconnections.Add(1)
+		conn.Close()
+	})
+
+	// Connections accepted before requirepass was set stay authenticated.
+	commandLock.Lock()
+	c.authenticated = !defaultUserNeedsAuth()
+	commandLock.Unlock()
+
+	serverStats.connectionsReceived.Add(1)
+	clients.Store(c.id, c)
+	return c
+}
+
+// release unregisters the client, drops the keys it watches and its
+// subscriptions, and closes its connection once the pending replies are sent.
+func (c *client) release() {
+	commandLock.Lock()
+	unwatchAllKeys(c)
+	unsubscribeAll(c)
+	commandLock.Unlock()
+
+	clients.Delete(c.id)
+	c.out.close()
+	c.conn.Close()
+}
+
+func (c *client) hasFlag(flag clientFlag) bool {
+	return c.flags&flag != 0
+}
+
+// info formats the client the way CLIENT LIST and CLIENT INFO report it.
+func (c *client) info() string {
+	now := time.Now()
+
+	flags := ""
+	if c.hasFlag(flagMulti) {
+		flags += "x"
+	}
+	if c.isSubscribed() {
+		flags += "P"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	multi := -1
+	if c.hasFlag(flagMulti) {
+		multi = len(c.queue)
+	}
+
+	cmd := strings.ToLower(c.lastCommand)
+	if cmd == "" {
+		cmd = "NULL"
+	}
+
+	addr, laddr := clientAddresses(c.conn)
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=%d multi=%d omem=%d cmd=%s user=%s resp=%d",
+		c.id, addr, laddr, c.name,
+		int(now.Sub(c.createdAt).Seconds()), int(now.Sub(c.lastInteraction).Seconds()),
+		flags, c.db.id, len(c.channels), len(c.patterns), len(c.shardChannels), multi, c.out.size(), cmd, c.user, c.out.protocol())
+}
+
+// clientCommand handles the CLIENT command and its ID, GETNAME, SETNAME, INFO
+// and LIST subcommands.
+func clientCommand(c *client, args []string) {
+	subCommand := strings.ToUpper(args[0])
+
+	switch {
+	case subCommand == "ID" && len(args) == 1:
+		c.out.writeInteger(int64(c.id))
+	case subCommand == "GETNAME" && len(args) == 1:
+		if c.name == "" {
+			c.out.writeNull()
+			return
+		}
+		c.out.writeBulkString(c.name)
+	case subCommand == "SETNAME" && len(args) == 2:
+		if !isValidClientName(args[1]) {
+			c.out.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.name = args[1]
+		c.out.writeSimpleString("OK")
+	case subCommand == "INFO" && len(args) == 1:
+		c.out.writeVerbatim("txt", c.info()+"\n")
+	case subCommand == "LIST" && len(args) == 1:
+		var list []*client
+		clients.Range(func(key, value interface{}) bool {
+			list = append(list, value.(*client))
+			return true
+		})
+		sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+
+		var b strings.Builder
+		for _, cl := range list {
+			b.WriteString(cl.info())
+			b.WriteString("\n")
+		}
+		c.out.writeVerbatim("txt", b.String())
+	default:
+		c.out.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[0]))
+	}
+}
+
+// isValidClientName reports whether name only contains printable, non-space
+// characters, as required by CLIENT SETNAME.
+func isValidClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// helloCommand handles the HELLO [protover [AUTH username password] [SETNAME clientname]]
+// command which switches the connection to RESP2 or RESP3, optionally
+// authenticating and naming it at the same time. It replies with a map
+// describing the server and the connection.
+func helloCommand(c *client, args []string) {
+	proto := c.out.protocol()
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			c.out.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			c.out.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = version
+	}
+
+	var user, password, name string
+	setName := false
+	for i := 1; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "AUTH" && i+2 < len(args):
+			user, password = args[i+1], args[i+2]
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			name = args[i+1]
+			if !isValidClientName(name) {
+				c.out.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+				return
+			}
+			setName = true
+			i++
+		default:
+			c.out.writeError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+			return
+		}
+	}
+
+	if user != "" {
+		if !authenticate(c, user, password) {
+			return
+		}
+	} else if !c.authenticated {
+		c.out.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	if setName {
+		c.name = name
+	}
+	c.out.setProto(proto)
+
+	c.out.writeMapLen(7)
+	c.out.writeBulkString("server")
+	c.out.writeBulkString("redis")
+	c.out.writeBulkString("version")
+	c.out.writeBulkString(redisVersion)
+	c.out.writeBulkString("proto")
+	c.out.writeInteger(int64(proto))
+	c.out.writeBulkString("id")
+	c.out.writeInteger(c.id)
+	c.out.writeBulkString("mode")
+	c.out.writeBulkString("standalone")
+	c.out.writeBulkString("role")
+	c.out.writeBulkString("master")
+	c.out.writeBulkString("modules")
+	c.out.writeArrayLen(0)
+}
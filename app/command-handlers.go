@@ -3,32 +3,189 @@ package main
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
-type CommandHandler func(args []string) string
-
-var registry = map[string]struct {
-	minArgs int
-	maxArgs int
-	handler CommandHandler
-}{
-	"SET":    {2, 4, setCommand},
-	"GET":    {1, 1, getCommand},
-	"PING":   {0, 0, pingCommand},
-	"ECHO":   {1, 1, echoCommand},
-	"CONFIG": {2, 2, configCommand},
-	"KEYS":   {1, 1, keysCommand},
+// CommandHandler executes a command on behalf of client c and writes its reply
+// with c.out. The reply is sent when the connection flushes its output.
+type CommandHandler func(c *client, args []string)
+
+// variadic is used as maxArgs for commands that accept any number of arguments.
+const variadic = -1
+
+// commandEntry describes a command: how many arguments it takes, the function
+// running it, and the metadata ACL rules are checked against.
+type commandEntry struct {
+	minArgs    int
+	maxArgs    int
+	handler    CommandHandler
+	categories aclCategory // ACL categories, see aclCategoryNames
+	keys       []keySpec   // Arguments that are keys, nil if none
+}
+
+// keySpec locates keys in the arguments of a command: args[first],
+// args[first+step], ... up to args[last]. A negative last counts from the end
+// of the arguments, so -1 is the last one.
+type keySpec struct {
+	first  int
+	last   int
+	step   int
+	access keyAccess
+}
+
+// keyAccess tells whether a command reads or writes a key, for %R~ and %W~ ACL
+// key patterns.
+type keyAccess int
+
+const (
+	keyRead keyAccess = 1 << iota
+	keyWrite
+	keyReadWrite = keyRead | keyWrite
+)
+
+// Key specs shared by many commands.
+var (
+	firstKeyRead  = []keySpec{{0, 0, 1, keyRead}}
+	firstKeyWrite = []keySpec{{0, 0, 1, keyWrite}}
+	allKeysRead   = []keySpec{{0, -1, 1, keyRead}}
+	allKeysWrite  = []keySpec{{0, -1, 1, keyWrite}}
+)
+
+// registry maps command names to their entry. It is filled by init because
+// some commands (EXEC, ACL) look other commands up in it, which a package-level
+// initializer is not allowed to refer to.
+var registry map[string]commandEntry
+
+func init() {
+	registry = map[string]commandEntry{
+		"SET":    {2, 4, setCommand, catWrite | catString | catSlow, firstKeyWrite},
+		"GET":    {1, 1, getCommand, catRead | catString | catFast, firstKeyRead},
+		"PING":   {0, 1, pingCommand, catFast | catConnection, nil},
+		"ECHO":   {1, 1, echoCommand, catFast | catConnection, nil},
+		"CONFIG": {1, variadic, configCommand, catAdmin | catSlow | catDangerous, nil},
+		"KEYS":   {1, 1, keysCommand, catKeyspace | catRead | catSlow | catDangerous, nil},
+
+		"DEL":       {1, variadic, delCommand, catKeyspace | catWrite | catSlow, allKeysWrite},
+		"UNLINK":    {1, variadic, delCommand, catKeyspace | catWrite | catFast, allKeysWrite},
+		"EXISTS":    {1, variadic, existsCommand, catKeyspace | catRead | catFast, allKeysRead},
+		"TOUCH":     {1, variadic, touchCommand, catKeyspace | catRead | catFast, allKeysRead},
+		"RENAME":    {2, 2, renameCommand, catKeyspace | catWrite | catSlow, []keySpec{{0, 0, 1, keyReadWrite}, {1, 1, 1, keyWrite}}},
+		"RENAMENX":  {2, 2, renamenxCommand, catKeyspace | catWrite | catFast, []keySpec{{0, 0, 1, keyReadWrite}, {1, 1, 1, keyWrite}}},
+		"COPY":      {2, 5, copyCommand, catKeyspace | catWrite | catSlow, []keySpec{{0, 0, 1, keyRead}, {1, 1, 1, keyWrite}}},
+		"RANDOMKEY": {0, 0, randomkeyCommand, catKeyspace | catRead | catSlow, nil},
+		"DBSIZE":    {0, 0, dbsizeCommand, catKeyspace | catRead | catFast, nil},
+		"TYPE":      {1, 1, typeCommand, catKeyspace | catRead | catFast, firstKeyRead},
+
+		"SCAN":  {1, 7, scanCommand, catKeyspace | catRead | catSlow, nil},
+		"HSCAN": {2, 6, collectionScanCommand("hash"), catRead | catHash | catSlow, firstKeyRead},
+		"SSCAN": {2, 6, collectionScanCommand("set"), catRead | catSet | catSlow, firstKeyRead},
+		"ZSCAN": {2, 6, collectionScanCommand("zset"), catRead | catSortedSet | catSlow, firstKeyRead},
+
+		"SELECT":   {1, 1, selectCommand, catFast | catConnection, nil},
+		"MOVE":     {2, 2, moveCommand, catKeyspace | catWrite | catFast, []keySpec{{0, 0, 1, keyReadWrite}}},
+		"SWAPDB":   {2, 2, swapdbCommand, catKeyspace | catWrite | catFast | catDangerous, nil},
+		"FLUSHDB":  {0, 1, flushdbCommand, catKeyspace | catWrite | catSlow | catDangerous, nil},
+		"FLUSHALL": {0, 1, flushallCommand, catKeyspace | catWrite | catSlow | catDangerous, nil},
+
+		"CLIENT":   {1, variadic, clientCommand, catSlow | catConnection, nil},
+		"INFO":     {0, variadic, infoCommand, catSlow | catDangerous, nil},
+		"SHUTDOWN": {0, variadic, shutdownCommand, catAdmin | catSlow | catDangerous, nil},
+		"QUIT":     {0, variadic, quitCommand, catFast | catConnection, nil},
+		"AUTH":     {1, 2, authCommand, catFast | catConnection, nil},
+		"HELLO":    {0, variadic, helloCommand, catFast | catConnection, nil},
+		"ACL":      {1, variadic, aclCommand, catAdmin | catSlow | catDangerous, nil},
+
+		"MULTI":   {0, 0, multiCommand, catFast | catTransaction, nil},
+		"EXEC":    {0, 0, execCommand, catSlow | catTransaction, nil},
+		"DISCARD": {0, 0, discardCommand, catFast | catTransaction, nil},
+		"WATCH":   {1, variadic, watchCommand, catFast | catTransaction, allKeysRead},
+		"UNWATCH": {0, 0, unwatchCommand, catFast | catTransaction, nil},
+
+		"SUBSCRIBE":    {1, variadic, subscribeCommand, catPubSub | catSlow, nil},
+		"UNSUBSCRIBE":  {0, variadic, unsubscribeCommand, catPubSub | catSlow, nil},
+		"PSUBSCRIBE":   {1, variadic, psubscribeCommand, catPubSub | catSlow, nil},
+		"PUNSUBSCRIBE": {0, variadic, punsubscribeCommand, catPubSub | catSlow, nil},
+		"PUBLISH":      {2, 2, publishCommand, catPubSub | catFast, nil},
+		"PUBSUB":       {1, variadic, pubsubCommand, catPubSub | catSlow, nil},
+		"SSUBSCRIBE":   {1, variadic, ssubscribeCommand, catPubSub | catSlow, nil},
+		"SUNSUBSCRIBE": {0, variadic, sunsubscribeCommand, catPubSub | catSlow, nil},
+		"SPUBLISH":     {2, 2, spublishCommand, catPubSub | catFast, nil},
+	}
+}
+
+// containerCommands are the commands whose first argument is a subcommand.
+// ACL rules and denials name their subcommands as "config|get".
+var containerCommands = map[string]bool{"ACL": true, "CLIENT": true, "CONFIG": true, "PUBSUB": true}
+
+// commandLock is held while a command runs, so that commands from different
+// connections never interleave against the databases. EXEC holds it for the
+// whole transaction, which is what makes transactions atomic.
+var commandLock sync.Mutex
+
+func handleCommand(c *client, command string, args []string) {
+	// The lock also guards the client fields other connections read through
+	// CLIENT LIST and ACL LOG, and the ACL users and pub/sub state checked below.
+	commandLock.Lock()
+	defer commandLock.Unlock()
+
+	c.lastInteraction = time.Now()
+	c.lastCommand = command
+
+	cmd, errMsg := lookupCommand(command, args)
+	if errMsg != "" {
+		flagTransactionError(c)
+		c.out.writeError(errMsg)
+		return
+	}
+
+	name := strings.ToUpper(command)
+	if !c.authenticated && !isAuthExempt(name) {
+		flagTransactionError(c)
+		c.out.writeError("NOAUTH Authentication required.")
+		return
+	}
+
+	if denial := aclCheckCommand(c.user, name, cmd, args); denial.reason != aclAllowed {
+		logACLDenial(c, denial, aclLogContext(c))
+		flagTransactionError(c)
+		c.out.writeError("NOPERM " + denial.message())
+		return
+	}
+
+	if c.isSubscribed() && c.out.protocol() == 2 && !isPubSubAllowed(name) {
+		c.out.writeError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+			strings.ToLower(command)))
+		return
+	}
+
+	if c.hasFlag(flagMulti) && !isTransactionCommand(name) {
+		c.queue = append(c.queue, queuedCommand{name: name, args: args, handler: cmd.handler})
+		c.out.writeSimpleString("QUEUED")
+		return
+	}
+
+	cmd.handler(c, args)
+	serverStats.commandsProcessed.Add(1)
 }
 
-func handleCommand(command string, args []string) string {
-	cmd, exists := registry[strings.ToUpper(command)]
+// lookupCommand finds the registry entry of command and checks the number of
+// arguments against it.
+//
+// Returns:
+//   - The registry entry
+//   - errorMessage: error reply message if the command is unknown or called
+//     with the wrong number of arguments
+func lookupCommand(command string, args []string) (cmd commandEntry, errorMessage string) {
+	name := strings.ToUpper(command)
+	cmd, exists := registry[name]
 	if !exists {
-		return fmt.Sprintf("-ERR unknown command '%s'\r\n", command)
+		return cmd, fmt.Sprintf("ERR unknown command '%s'", command)
 	}
 
-	if len(args) < cmd.minArgs || len(args) > cmd.maxArgs {
-		return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToUpper(command))
+	if len(args) < cmd.minArgs || (cmd.maxArgs != variadic && len(args) > cmd.maxArgs) {
+		return cmd, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)
 	}
 
-	return cmd.handler(args)
+	return cmd, ""
 }
@@ -11,40 +11,69 @@ type storedValue struct {
 	expiresAt time.Time
 }
 
-func pingCommand(args []string) string {
+// pingCommand handles the PING [message] command. In subscribed mode, RESP2
+// clients get the reply as a two-element array, like the frames pushed to them.
+func pingCommand(c *client, args []string) {
+	message := ""
+	if len(args) == 1 {
+		message = args[0]
+	}
+
+	switch {
+	case c.isSubscribed() && c.out.protocol() == 2:
+		c.out.writeBulkStrings("pong", message)
+	case len(args) == 1:
+		c.out.writeBulkString(message)
+	default:
+		c.out.writeSimpleString("PONG")
+	}
+}
+
+// quitCommand handles the QUIT command: the connection is closed once the +OK
+// reply has been written.
+func quitCommand(c *client, args []string) {
 	_ = args
-	return "+PONG\r\n"
+	c.flags |= flagCloseAfterReply
+	c.out.writeSimpleString("OK")
 }
 
-func echoCommand(args []string) string {
+func echoCommand(c *client, args []string) {
 	if len(args) == 0 {
-		return "-ERR wrong number of arguments for 'echo' command\r\n"
+		c.out.writeError("ERR wrong number of arguments for 'echo' command")
+		return
 	}
 
-	return fmt.Sprintf("$%d\r\n%s\r\n", len(args[0]), args[0])
+	c.out.writeBulkString(args[0])
 }
 
-func setCommand(args []string) string {
+func setCommand(c *client, args []string) {
 	if len(args) != 2 && len(args) != 4 {
-		return "-ERR wrong number of arguments for 'SET' command\r\n"
+		c.out.writeError("ERR wrong number of arguments for 'SET' command")
+		return
 	}
 
 	var expiresAt time.Time
-	var errStr string
+	var errMsg string
 
 	if len(args) == 4 {
-		expiresAt, errStr = parseCommandExpiry(args)
-		if errStr != "" {
-			return errStr
+		expiresAt, errMsg = parseCommandExpiry(args)
+		if errMsg != "" {
+			c.out.writeError(errMsg)
+			return
 		}
 	}
 
-	storage.Store(args[0], &storedValue{
+	c.db.setKey(args[0], &storedValue{
 		value:     args[1],
 		expiresAt: expiresAt,
 	})
 
-	return "+OK\r\n"
+	notifyKeyspaceEvent(notifyString, "set", args[0], c.db)
+	if !expiresAt.IsZero() {
+		notifyKeyspaceEvent(notifyGeneric, "expire", args[0], c.db)
+	}
+
+	c.out.writeSimpleString("OK")
 }
 
 // getCommand handles the GET command which retrieves the value of a key.
@@ -52,107 +81,157 @@ func setCommand(args []string) string {
 // Parameters:
 //   - args: Command arguments where args[0] is the key to retrieve
 //
-// Returns:
+// Replies:
 //   - RESP (Redis Serialization Protocol) formatted string:
 //   - For existing key: "$<length>\r\n<value>\r\n"
-//   - For non-existing key: "$-1\r\n" (null bulk string)
-//   - For expired key: "$-1\r\n" (null bulk string)
-//   - For invalid data: "$-1\r\n" (null bulk string)
+//   - For non-existing key: "$-1\r\n" (null bulk string, "_\r\n" in RESP3)
+//   - For expired key: "$-1\r\n" (null bulk string, "_\r\n" in RESP3)
+//   - For invalid data: "$-1\r\n" (null bulk string, "_\r\n" in RESP3)
 //
 // Example:
 //
 //	Input: ["foo"]
 //	Output: "$3\r\nbar\r\n" (if key "foo" has value "bar")
-func getCommand(args []string) string {
+func getCommand(c *client, args []string) {
 	if len(args) != 1 {
-		return "-ERR wrong number of arguments for 'GET' command\r\n"
+		c.out.writeError("ERR wrong number of arguments for 'GET' command")
+		return
 	}
 
-	val, ok := storage.Load(args[0])
+	sv, ok := c.db.lookupKey(args[0])
 	if !ok {
 		fmt.Printf("DEBUG: Key %s not found in storage\n", args[0])
-		return "$-1\r\n"
+		c.out.writeNull() // Null bulk string for missing keys
+		return
 	}
 
-	// Type assertion and expiration check
-	sv, ok := val.(*storedValue)
-	if !ok {
-		fmt.Printf("DEBUG: Invalid data format for key %s, cleaning up\n", args[0])
-		storage.Delete(args[0])
-		return "$-1\r\n"
+	fmt.Printf("DEBUG: Returning value for key %s: %s\n", args[0], sv.value)
+	c.out.writeBulkString(sv.value)
+}
+
+// configCommand handles the CONFIG command:
+//   - CONFIG GET pattern [pattern ...]: map of the parameters matching any of the glob patterns to their value
+//   - CONFIG SET parameter value [parameter value ...]: changes mutable parameters at runtime
+//   - CONFIG REWRITE: stores the current configuration in the configuration file
+//   - CONFIG RESETSTAT: resets the statistics reported by INFO
+func configCommand(c *client, args []string) {
+	subCommand := strings.ToUpper(args[0])
+
+	switch {
+	case subCommand == "GET" && len(args) >= 2:
+		configGet(c, args[1:])
+	case subCommand == "SET" && len(args) >= 3 && len(args)%2 == 1:
+		configSet(c, args[1:])
+	case subCommand == "REWRITE" && len(args) == 1:
+		if err := rewriteConfigFile(); err != nil {
+			if err != errNoConfigFile {
+				fmt.Println("CONFIG REWRITE failed:", err)
+				err = fmt.Errorf("Rewriting config file: %v", err)
+			}
+			c.out.writeError("ERR " + err.Error())
+			return
+		}
+		c.out.writeSimpleString("OK")
+	case subCommand == "RESETSTAT" && len(args) == 1:
+		resetServerStats()
+		c.out.writeSimpleString("OK")
+	default:
+		c.out.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[0]))
 	}
+}
 
-	// Check expiration if set
-	if !sv.expiresAt.IsZero() {
-		currentTime := time.Now()
-		if currentTime.After(sv.expiresAt) {
-			fmt.Printf("DEBUG: Key %s expired at %v (current time: %v)\n",
-				args[0], sv.expiresAt, currentTime)
-			storage.Delete(args[0])
-			return "$-1\r\n" // Null bulk string for missing keys
-		} else {
-			fmt.Printf("DEBUG: Key %s not expired (expires at %v, current time: %v)\n",
-				args[0], sv.expiresAt, currentTime)
+// configGet writes every parameter whose name matches one of the glob patterns
+// as a name/value pair, e.g. "CONFIG GET d* port" returns dir, dbfilename,
+// databases and port. A parameter matched by several patterns is returned once.
+func configGet(c *client, patterns []string) {
+	var pairs []string
+	for _, p := range configParameters {
+		for _, pattern := range patterns {
+			if stringMatch(strings.ToLower(pattern), p.name, true) {
+				pairs = append(pairs, p.name, p.value.get())
+				break
+			}
 		}
-	} else {
-		fmt.Printf("DEBUG: Key %s has no expiration\n", args[0])
 	}
 
-	fmt.Printf("DEBUG: Returning value for key %s: %s\n", args[0], sv.value)
-	return fmt.Sprintf("$%d\r\n%s\r\n", len(sv.value), sv.value)
+	c.out.writeMapLen(len(pairs) / 2)
+	for _, s := range pairs {
+		c.out.writeBulkString(s)
+	}
 }
 
-func configCommand(args []string) string {
-	subCommand := strings.ToUpper(args[0])
-	parameter := strings.ToLower(args[1])
-
-	switch subCommand {
-	case "GET":
-		switch parameter {
-		case "dir":
-			return fmt.Sprintf("*2\r\n$3\r\ndir\r\n$%d\r\n%s\r\n",
-				len(config.dir), config.dir)
-		case "dbfilename":
-			return fmt.Sprintf("*2\r\n$10\r\ndbfilename\r\n$%d\r\n%s\r\n",
-				len(config.dbFilename), config.dbFilename)
-		default:
-			return "*0\r\n"
+// configSet applies name/value pairs atomically: if any value is rejected, the
+// parameters already changed are restored to their previous value.
+func configSet(c *client, pairs []string) {
+	params := make([]*configParameter, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		p := lookupConfigParameter(pairs[i])
+		if p == nil {
+			c.out.writeError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i]))
+			return
 		}
-	default:
-		return "-ERR unknown subcommand\r\n"
+		if !p.mutable {
+			c.out.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", pairs[i]))
+			return
+		}
+		for _, other := range params {
+			if other == p {
+				c.out.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", pairs[i]))
+				return
+			}
+		}
+		params = append(params, p)
 	}
+
+	previous := make([]string, len(params))
+	for i, p := range params {
+		previous[i] = p.value.get()
+	}
+
+	for i, p := range params {
+		if err := p.setValue(pairs[2*i+1]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				params[j].setValue(previous[j])
+			}
+			c.out.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", pairs[2*i], err))
+			return
+		}
+	}
+	c.out.writeSimpleString("OK")
 }
 
-// keysCommand handles the KEYS command which returns all keys matching a pattern.
-// Currently, it only supports the "*" pattern which matches all keys.
+// keysCommand handles the KEYS command which returns all keys matching a glob-style
+// pattern (see stringMatch for the supported syntax). Expired keys are skipped and
+// removed while walking the storage.
 //
 // Parameters:
 //   - args: Command arguments where args[0] is the pattern to match
 //
-// Returns:
+// Replies:
 //   - RESP (Redis Serialization Protocol) formatted string:
-//   - For successful match: "*<count>\r\n$<length>\r\n<key>\r\n..." for each key
-//   - For unsupported pattern: "-ERR pattern not supported\r\n"
+//   - "*<count>\r\n$<length>\r\n<key>\r\n..." for each matching key
 //
 // Example:
 //
-//	Input: ["*"]
-//	Output: "*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n" (for keys "foo" and "bar")
-func keysCommand(args []string) string {
-	if args[0] != "*" {
-		return "-ERR pattern not supported\r\n"
-	}
-
-	var keys []string
-	storage.Range(func(key, value interface{}) bool {
-		keys = append(keys, key.(string))
+//	Input: ["f*"]
+//	Output: "*2\r\n$3\r\nfoo\r\n$4\r\nfizz\r\n" (for keys "foo", "fizz" and "bar")
+func keysCommand(c *client, args []string) {
+	pattern := args[0]
+	allKeys := pattern == "*"
+
+	// The matches are written as they are found and the length of the array
+	// is set once they are all known.
+	c.out.writeDeferredArrayLen()
+	count := 0
+	c.db.rangeKeys(func(k string) bool {
+		if !allKeys && !stringMatch(pattern, k, false) {
+			return true
+		}
+		if _, ok := c.db.lookupKey(k); ok {
+			c.out.writeBulkString(k)
+			count++
+		}
 		return true
 	})
-
-	resp := fmt.Sprintf("*%d\r\n", len(keys))
-	for _, key := range keys {
-		resp += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
-	}
-
-	return resp
+	c.out.setDeferredArrayLen(count)
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configRewriteSignature precedes the lines CONFIG REWRITE appends to the
+// configuration file.
+const configRewriteSignature = "# Generated by CONFIG REWRITE"
+
+// errNoConfigFile is returned by CONFIG REWRITE when the server was started
+// without a configuration file.
+var errNoConfigFile = errors.New("The server is running without a config file")
+
+// configFileError describes an invalid line of the configuration file the way
+// Redis reports it before exiting.
+type configFileError struct {
+	lineno int
+	line   string
+	msg    string
+}
+
+func (e *configFileError) Error() string {
+	return fmt.Sprintf("\n*** FATAL CONFIG FILE ERROR (Redis %s) ***\nReading the configuration file, at line %d\n>>> '%s'\n%s",
+		redisVersion, e.lineno, e.line, e.msg)
+}
+
+// loadConfigFile applies the redis.conf-style file at path: one
+// "name value" directive per line, where values may be quoted like inline
+// commands. Blank lines and lines starting with '#' are ignored. A directive
+// repeated later in the file overrides the earlier one, except multi-argument
+// directives, which add to it: "save 900 1" and "save 300 10" on two lines
+// set both snapshot points.
+//
+// Example:
+//
+//	Input: "port 7000\nbind 127.0.0.1 ::1\ndir \"/var/lib/my redis\"\n"
+//	Output: port 7000, two bind addresses and a dir with a space
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Fatal error, can't open config file '%s': %v", path, err)
+	}
+
+	seen := map[*configParameter]bool{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		args, ok := splitInlineArgs(line)
+		if !ok {
+			return &configFileError{i + 1, line, "Unbalanced quotes in configuration line"}
+		}
+
+		p := lookupConfigParameter(args[0])
+		if p == nil || len(args) < 2 || (!p.multiArg && len(args) != 2) {
+			return &configFileError{i + 1, line, "Bad directive or wrong number of arguments"}
+		}
+
+		value := strings.Join(args[1:], " ")
+		if seen[p] && p.multiArg {
+			value = p.value.get() + " " + value
+		}
+		seen[p] = true
+		if err := p.setValue(value); err != nil {
+			return &configFileError{i + 1, line, err.Error()}
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	config.configfile = abs
+	return nil
+}
+
+// configLine formats the parameter as a configuration file directive.
+// Multi-argument values are written as separate words; other values are quoted
+// when they would not read back as a single word.
+//
+// Example:
+//
+//	Input: dir set to "/var/lib/my redis"
+//	Output: "dir \"/var/lib/my redis\""
+func (p *configParameter) configLine() string {
+	value := p.value.get()
+	if p.multiArg && value != "" {
+		return p.name + " " + value
+	}
+	return p.name + " " + quoteConfigValue(value)
+}
+
+// quoteConfigValue returns s unchanged if it is a single plain word, and as a
+// double-quoted string with escapes that splitInlineArgs reads back otherwise.
+func quoteConfigValue(s string) string {
+	plain := s != ""
+	for i := 0; i < len(s) && plain; i++ {
+		ch := s[i]
+		plain = ch > ' ' && ch < 0x7f && ch != '"' && ch != '\'' && ch != '\\'
+	}
+	if plain {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			if ch < ' ' || ch >= 0x7f {
+				fmt.Fprintf(&b, "\\x%02x", ch)
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// rewriteConfigFile updates the configuration file with the current values.
+// Comments, blank lines and unknown directives are kept in place. The first
+// directive of each parameter is replaced with its current value and later
+// ones are dropped. Parameters missing from the file are appended after
+// configRewriteSignature, unless they are at their default.
+func rewriteConfigFile() error {
+	if config.configfile == "" {
+		return errNoConfigFile
+	}
+
+	data, err := os.ReadFile(config.configfile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var old []string
+	if content := strings.TrimRight(string(data), "\n"); content != "" {
+		old = strings.Split(content, "\n")
+	}
+
+	var lines []string
+	written := map[*configParameter]bool{}
+	for _, line := range old {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == configRewriteSignature {
+			continue
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			lines = append(lines, line)
+			continue
+		}
+
+		var p *configParameter
+		if args, ok := splitInlineArgs(trimmed); ok {
+			p = lookupConfigParameter(args[0])
+		}
+		switch {
+		case p == nil:
+			lines = append(lines, line)
+		case !written[p]:
+			lines = append(lines, p.configLine())
+			written[p] = true
+		}
+	}
+
+	var added []string
+	for _, p := range configParameters {
+		if !written[p] && p.value.get() != configDefaults[p.name] {
+			added = append(added, p.configLine())
+		}
+	}
+	if len(added) > 0 {
+		lines = append(lines, configRewriteSignature)
+		lines = append(lines, added...)
+	}
+
+	return writeFileAtomic(config.configfile, strings.Join(lines, "\n")+"\n")
+}
@@ -1,14 +1,437 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
 var config = struct {
-	dir        string
-	dbFilename string
+	dir                  string
+	dbFilename           string
+	databases            int
+	notifyKeyspaceEvents int
+	requirepass          string // Password of the default user, empty for none
+	aclfile              string // File ACL LOAD and ACL SAVE use, empty for none
+	acllogMaxLen         int    // Number of entries ACL LOG keeps
+
+	port           int         // Port of the TCP listener, 0 when TCP is disabled
+	bind           []string    // Addresses the TCP and TLS listeners bind, see bindNetworkAddress
+	unixsocket     string      // Path of the Unix domain socket, empty for none
+	unixsocketperm os.FileMode // Permissions of unixsocket, 0 to keep the default
+
+	tlsPort        int    // Port of the TLS listener, 0 when TLS is disabled
+	tlsCertFile    string // Server certificate
+	tlsKeyFile     string // Private key of tlsCertFile
+	tlsCACertFile  string // CA certificates client certificates are verified against
+	tlsAuthClients string // "yes", "no" or "optional"
+
+	protectedMode bool // Whether non-loopback clients are refused while the server is open, see protectedModeDenies
+
+	clusterEnabled bool // Whether the server is a node of a sharded deployment, see sameSlot
+
+	save []string // Snapshot points as "seconds changes" pairs, empty when persistence is off
+
+	configfile string // Absolute path of the configuration file, empty if there is none
+
+	// Request size limits, read by the connection goroutines while parsing.
+	protoMaxBulkLen        atomic.Int64 // Largest bulk string accepted in a request
+	protoMaxMultibulkLen   atomic.Int64 // Largest number of arguments accepted in a request
+	clientQueryBufferLimit atomic.Int64 // Largest request a client may send
 }{
-	dir:        ".",
-	dbFilename: "dump.rdb",
+	dir:            ".",
+	dbFilename:     "dump.rdb",
+	databases:      16,
+	acllogMaxLen:   128,
+	port:           6379,
+	bind:           []string{"*", "-::*"},
+	tlsAuthClients: "yes",
+	protectedMode:  true,
+}
+
+func init() {
+	config.protoMaxBulkLen.Store(512 << 20)
+	config.protoMaxMultibulkLen.Store(1024 * 1024)
+	config.clientQueryBufferLimit.Store(1 << 30)
+}
+
+// configValue is the typed storage of a configuration parameter. get formats
+// the value the way CONFIG GET and CONFIG REWRITE show it, and set validates
+// and stores a new value.
+type configValue interface {
+	get() string
+	set(value string) error
+}
+
+// boolConfig is a yes/no parameter.
+type boolConfig struct {
+	target *bool
+}
+
+func (v boolConfig) get() string {
+	if *v.target {
+		return "yes"
+	}
+	return "no"
+}
+
+func (v boolConfig) set(value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*v.target = true
+	case "no":
+		*v.target = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+// intConfig is an integer parameter between min and max inclusive.
+type intConfig struct {
+	target   *int
+	min, max int
+}
+
+func (v intConfig) get() string {
+	return strconv.Itoa(*v.target)
 }
 
-func initConfig(dir, filename string) {
-	config.dir = dir
-	config.dbFilename = filename
+func (v intConfig) set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("argument couldn't be parsed into an integer")
+	}
+	if n < v.min || n > v.max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", v.min, v.max)
+	}
+	*v.target = n
+	return nil
+}
+
+// atomicIntConfig is an integer parameter between min and max inclusive whose
+// target is atomic, because connection goroutines read it without commandLock.
+type atomicIntConfig struct {
+	target   *atomic.Int64
+	min, max int64
+}
+
+func (v atomicIntConfig) get() string {
+	return strconv.FormatInt(v.target.Load(), 10)
+}
+
+func (v atomicIntConfig) set(value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("argument couldn't be parsed into an integer")
+	}
+	if n < v.min || n > v.max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", v.min, v.max)
+	}
+	v.target.Store(n)
+	return nil
+}
+
+// memoryConfig is a memory size of at least min bytes, see parseMemory. Its
+// target is atomic because connection goroutines read it without commandLock.
+type memoryConfig struct {
+	target *atomic.Int64
+	min    int64
+}
+
+func (v memoryConfig) get() string {
+	return strconv.FormatInt(v.target.Load(), 10)
+}
+
+func (v memoryConfig) set(value string) error {
+	size, err := parseMemory(value)
+	if err != nil {
+		return err
+	}
+	if size < v.min {
+		return fmt.Errorf("argument must be between %d and %d inclusive", v.min, int64(math.MaxInt64))
+	}
+	v.target.Store(size)
+	return nil
+}
+
+// enumConfig is a parameter taking one of values, matched case-insensitively.
+type enumConfig struct {
+	target *string
+	values []string
+}
+
+func (v enumConfig) get() string {
+	return *v.target
+}
+
+func (v enumConfig) set(value string) error {
+	for _, allowed := range v.values {
+		if strings.EqualFold(value, allowed) {
+			*v.target = allowed
+			return nil
+		}
+	}
+	return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(v.values, ", "))
+}
+
+// stringConfig is a free-form string parameter.
+type stringConfig struct {
+	target *string
+}
+
+func (v stringConfig) get() string {
+	return *v.target
+}
+
+func (v stringConfig) set(value string) error {
+	*v.target = value
+	return nil
+}
+
+// stringListConfig is a list of space-separated words, like bind.
+type stringListConfig struct {
+	target *[]string
+}
+
+func (v stringListConfig) get() string {
+	return strings.Join(*v.target, " ")
+}
+
+func (v stringListConfig) set(value string) error {
+	*v.target = strings.Fields(value)
+	return nil
+}
+
+// specialConfig is a parameter with its own format, given by getter and setter.
+type specialConfig struct {
+	getter func() string
+	setter func(value string) error
+}
+
+func (v specialConfig) get() string {
+	return v.getter()
+}
+
+func (v specialConfig) set(value string) error {
+	return v.setter(value)
+}
+
+// configParameter is a parameter that can be set in the configuration file, on
+// the command line and, if it is mutable, with CONFIG SET.
+type configParameter struct {
+	name     string
+	value    configValue
+	mutable  bool
+	multiArg bool   // The value spans several arguments in redis.conf, e.g. "bind 127.0.0.1 ::1"
+	apply    func() // Called after the value changes, nil if nothing depends on it
+}
+
+// setValue validates and stores value, then applies it.
+func (p *configParameter) setValue(value string) error {
+	if err := p.value.set(value); err != nil {
+		return err
+	}
+	if p.apply != nil {
+		p.apply()
+	}
+	return nil
+}
+
+// configParameters lists the parameters in the order CONFIG GET returns them.
+var configParameters = []*configParameter{
+	{name: "dir", value: stringConfig{&config.dir}},
+	{name: "dbfilename", value: stringConfig{&config.dbFilename}},
+	{name: "databases", value: intConfig{&config.databases, 1, math.MaxInt32}},
+	{name: "notify-keyspace-events", mutable: true, value: specialConfig{
+		func() string { return formatKeyspaceEvents(config.notifyKeyspaceEvents) },
+		func(value string) error {
+			flags, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			config.notifyKeyspaceEvents = flags
+			return nil
+		}}},
+	{name: "requirepass", mutable: true, value: stringConfig{&config.requirepass},
+		apply: func() { setRequirepass(config.requirepass) }},
+	{name: "aclfile", value: stringConfig{&config.aclfile}},
+	{name: "acllog-max-len", mutable: true, value: intConfig{&config.acllogMaxLen, 0, math.MaxInt32},
+		apply: trimACLLog},
+	{name: "protected-mode", mutable: true, value: boolConfig{&config.protectedMode}},
+	{name: "cluster-enabled", value: boolConfig{&config.clusterEnabled}},
+	{name: "save", mutable: true, multiArg: true, value: specialConfig{
+		func() string { return strings.Join(config.save, " ") },
+		func(value string) error {
+			fields := strings.Fields(value)
+			if len(fields)%2 != 0 {
+				return fmt.Errorf("Invalid save parameters")
+			}
+			for _, f := range fields {
+				if n, err := strconv.ParseInt(f, 10, 64); err != nil || n < 0 {
+					return fmt.Errorf("Invalid save parameters")
+				}
+			}
+			config.save = fields
+			return nil
+		}}},
+	{name: "port", value: intConfig{&config.port, 0, 65535}},
+	{name: "bind", multiArg: true, value: stringListConfig{&config.bind}},
+	{name: "unixsocket", value: stringConfig{&config.unixsocket}},
+	{name: "unixsocketperm", value: specialConfig{
+		func() string { return strconv.FormatUint(uint64(config.unixsocketperm), 8) },
+		func(value string) error {
+			perm, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || perm > 0777 {
+				return fmt.Errorf("argument must be an octal permission between 0 and 777")
+			}
+			config.unixsocketperm = os.FileMode(perm)
+			return nil
+		}}},
+	{name: "tls-port", value: intConfig{&config.tlsPort, 0, 65535}},
+	{name: "tls-cert-file", value: stringConfig{&config.tlsCertFile}},
+	{name: "tls-key-file", value: stringConfig{&config.tlsKeyFile}},
+	{name: "tls-ca-cert-file", value: stringConfig{&config.tlsCACertFile}},
+	{name: "tls-auth-clients", value: enumConfig{&config.tlsAuthClients, []string{"no", "yes", "optional"}}},
+	{name: "proto-max-bulk-len", mutable: true, value: memoryConfig{&config.protoMaxBulkLen, 1 << 20}},
+	{name: "proto-max-multibulk-len", mutable: true, value: atomicIntConfig{&config.protoMaxMultibulkLen, 1, math.MaxInt32}},
+	{name: "client-query-buffer-limit", mutable: true, value: memoryConfig{&config.clientQueryBufferLimit, 1 << 20}},
+	{name: "client-output-buffer-limit", mutable: true, multiArg: true,
+		value: specialConfig{formatOutputBufferLimits, setOutputBufferLimits}},
+}
+
+// configDefaults holds the value of every parameter before the configuration
+// file and the command line are applied. CONFIG REWRITE leaves parameters at
+// their default out of the file.
+var configDefaults = map[string]string{}
+
+// recordConfigDefaults fills configDefaults. It must run before anything
+// changes the configuration.
+func recordConfigDefaults() {
+	for _, p := range configParameters {
+		configDefaults[p.name] = p.value.get()
+	}
+}
+
+// lookupConfigParameter returns the parameter called name, case-insensitively,
+// or nil if there is none.
+func lookupConfigParameter(name string) *configParameter {
+	for _, p := range configParameters {
+		if strings.EqualFold(p.name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// configFlag sets a parameter from the command line, as --name value. Repeating
+// a multi-argument flag adds to its value instead of replacing it, so
+// "--bind 127.0.0.1 --bind ::1" binds both addresses.
+type configFlag struct {
+	param *configParameter
+	seen  bool
+}
+
+func (f *configFlag) String() string {
+	if f.param == nil {
+		return ""
+	}
+	return f.param.value.get()
+}
+
+func (f *configFlag) Set(value string) error {
+	if f.seen && f.param.multiArg {
+		value = f.param.value.get() + " " + value
+	}
+	f.seen = true
+	return f.param.setValue(value)
+}
+
+// registerConfigFlags adds a flag for every parameter to flags.
+func registerConfigFlags(flags *flag.FlagSet) {
+	for _, p := range configParameters {
+		flags.Var(&configFlag{param: p}, p.name, "Sets the "+p.name+" configuration parameter")
+	}
+}
+
+// groupConfigArgs rewrites command-line options the way redis-server reads
+// them: every argument after "--name" up to the next "--" option is part of
+// its value, so multi-argument parameters can be given in one go. Each option
+// becomes a single "--name=value" argument for the flag package.
+//
+// Returns:
+//   - an error for an argument that does not follow an option, or for several
+//     words given to a parameter that takes a single one
+//
+// Example:
+//
+//	Input: ["--bind", "127.0.0.1", "::1", "--port", "6395"]
+//	Output: ["--bind=127.0.0.1 ::1", "--port=6395"]
+func groupConfigArgs(args []string) ([]string, error) {
+	var names []string
+	var values [][]string
+	for _, arg := range args {
+		if len(arg) > 2 && strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			names = append(names, name)
+			values = append(values, nil)
+			if hasValue {
+				values[len(values)-1] = append(values[len(values)-1], value)
+			}
+			continue
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unexpected argument '%s', options must start with '--'", arg)
+		}
+		values[len(values)-1] = append(values[len(values)-1], arg)
+	}
+
+	grouped := make([]string, len(names))
+	for i, name := range names {
+		if p := lookupConfigParameter(name); p != nil && !p.multiArg && len(values[i]) > 1 {
+			return nil, fmt.Errorf("wrong number of arguments for '--%s': %s", name, strings.Join(values[i], " "))
+		}
+		grouped[i] = "--" + name + "=" + strings.Join(values[i], " ")
+	}
+	return grouped, nil
+}
+
+// parseMemory parses a memory size the way redis.conf spells them: a number of
+// bytes with an optional, case-insensitive unit. "k", "m" and "g" are powers of
+// 1000, "kb", "mb" and "gb" powers of 1024.
+//
+// Example:
+//
+//	Input: "512mb"
+//	Output: 536870912
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(s)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+
+	return n * multiplier, nil
 }
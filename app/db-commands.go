@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// selectCommand handles the SELECT command which changes the database used by
+// the connection for all the following commands.
+func selectCommand(c *client, args []string) {
+	index, err := strconv.Atoi(args[0])
+	if err != nil {
+		c.out.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	db, ok := getDatabase(index)
+	if !ok {
+		c.out.writeError("ERR DB index is out of range")
+		return
+	}
+
+	c.db = db
+	c.out.writeSimpleString("OK")
+}
+
+// moveCommand handles the MOVE command which moves a key (with its TTL) from the
+// selected database to another one.
+//
+// Returns:
+//   - ":1\r\n" if the key was moved
+//   - ":0\r\n" if the key does not exist or already exists in the target database
+func moveCommand(c *client, args []string) {
+	key := args[0]
+
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.out.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	dst, ok := getDatabase(index)
+	if !ok {
+		c.out.writeError("ERR DB index is out of range")
+		return
+	}
+	if dst == c.db {
+		c.out.writeError("ERR source and destination objects are the same")
+		return
+	}
+
+	sv, ok := c.db.lookupKey(key)
+	if !ok {
+		c.out.writeInteger(0)
+		return
+	}
+	if _, exists := dst.lookupKey(key); exists {
+		c.out.writeInteger(0)
+		return
+	}
+
+	c.db.removeKey(key)
+	notifyKeyspaceEvent(notifyGeneric, "move_from", key, c.db)
+	dst.setKey(key, sv)
+	notifyKeyspaceEvent(notifyGeneric, "move_to", key, dst)
+	c.out.writeInteger(1)
+}
+
+// swapdbCommand handles the SWAPDB command which exchanges the contents of two
+// databases. Connections that selected one of them see the other's keys from now on.
+func swapdbCommand(c *client, args []string) {
+	first, err := strconv.Atoi(args[0])
+	if err != nil {
+		c.out.writeError("ERR invalid first DB index")
+		return
+	}
+	second, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.out.writeError("ERR invalid second DB index")
+		return
+	}
+
+	a, ok := getDatabase(first)
+	if !ok {
+		c.out.writeError("ERR DB index is out of range")
+		return
+	}
+	b, ok := getDatabase(second)
+	if !ok {
+		c.out.writeError("ERR DB index is out of range")
+		return
+	}
+
+	if a != b {
+		swapDatabases(a, b)
+	}
+
+	c.out.writeSimpleString("OK")
+}
+
+// flushdbCommand handles the FLUSHDB [ASYNC|SYNC] command which removes every
+// key of the selected database. Both modes behave the same, see flush.
+func flushdbCommand(c *client, args []string) {
+	if errMsg := parseFlushMode(args); errMsg != "" {
+		c.out.writeError(errMsg)
+		return
+	}
+
+	c.db.flush()
+	c.out.writeSimpleString("OK")
+}
+
+// flushallCommand handles the FLUSHALL [ASYNC|SYNC] command which removes every
+// key of every database. Both modes behave the same, see flush.
+func flushallCommand(c *client, args []string) {
+	if errMsg := parseFlushMode(args); errMsg != "" {
+		c.out.writeError(errMsg)
+		return
+	}
+
+	for _, db := range databases {
+		db.flush()
+	}
+
+	c.out.writeSimpleString("OK")
+}
+
+// parseFlushMode validates the optional ASYNC|SYNC argument of FLUSHDB and
+// FLUSHALL.
+func parseFlushMode(args []string) (errorMessage string) {
+	if len(args) == 0 {
+		return ""
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ASYNC", "SYNC":
+		return ""
+	default:
+		return "ERR syntax error"
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// databases holds the logical databases selectable with SELECT, indexed by id.
+var databases []*database
+
+// database is a logical keyspace. Its keys live in a keyspace that is replaced
+// as a whole by FLUSHDB and SWAPDB, so connections keep pointing at the same
+// database while its contents change under them.
+type database struct {
+	id   int
+	keys atomic.Pointer[keyspace]
+}
+
+// keyspace holds the keys of a database and the indexes kept alongside them.
+// The indexes are only changed by setKey and the functions removing keys,
+// which run under commandLock.
+type keyspace struct {
+	entries sync.Map            // Thread-safe concurrent map for key-value storage
+	scan    *scanTable          // Every key, in the buckets SCAN walks
+	expires map[string]struct{} // The keys with an expiry, sampled by the active expiry cycle
+}
+
+func newKeyspace() *keyspace {
+	return &keyspace{scan: newScanTable(), expires: map[string]struct{}{}}
+}
+
+// forget removes key from the indexes once it was deleted from the entries.
+func (ks *keyspace) forget(key string) {
+	ks.scan.remove(key)
+	delete(ks.expires, key)
+}
+
+// initDatabases creates count empty logical databases.
+func initDatabases(count int) {
+	databases = make([]*database, count)
+	for i := range databases {
+		databases[i] = &database{id: i}
+		databases[i].keys.Store(newKeyspace())
+	}
+}
+
+// getDatabase returns the database with the given index.
+//
+// Returns:
+//   - The database and true if index is in range
+//   - nil and false otherwise
+func getDatabase(index int) (*database, bool) {
+	if index < 0 || index >= len(databases) {
+		return nil, false
+	}
+
+	return databases[index], true
+}
+
+// storage returns the map currently holding the database's keys.
+func (db *database) storage() *sync.Map {
+	return &db.keys.Load().entries
+}
+
+// lookupKey returns the value stored at key, applying the same expiry rules as GET:
+// expired keys and entries with an invalid format are deleted and reported as missing.
+//
+// Parameters:
+//   - key: The key to look up
+//
+// Returns:
+//   - The stored value and true if the key exists and has not expired
+//   - nil and false otherwise
+func (db *database) lookupKey(key string) (*storedValue, bool) {
+	ks := db.keys.Load()
+
+	val, ok := ks.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	// Type assertion and expiration check
+	sv, ok := val.(*storedValue)
+	if !ok {
+		fmt.Printf("DEBUG: Invalid data format for key %s, cleaning up\n", key)
+		if ks.entries.CompareAndDelete(key, val) {
+			ks.forget(key)
+		}
+		return nil, false
+	}
+
+	if sv.isExpired(time.Now()) {
+		fmt.Printf("DEBUG: Key %s expired at %v\n", key, sv.expiresAt)
+		if ks.entries.CompareAndDelete(key, val) {
+			ks.forget(key)
+			signalModifiedKey(db, key)
+			notifyKeyspaceEvent(notifyExpired, "expired", key, db)
+		}
+		return nil, false
+	}
+
+	return sv, true
+}
+
+// setKey stores sv at key, overwriting any previous value.
+func (db *database) setKey(key string, sv *storedValue) {
+	ks := db.keys.Load()
+	previous, loaded := ks.entries.Swap(key, sv)
+	if !loaded {
+		ks.scan.add(key)
+	}
+	if sv.expiresAt.IsZero() {
+		delete(ks.expires, key)
+	} else {
+		ks.expires[key] = struct{}{}
+	}
+	signalModifiedKey(db, key)
+
+	if old, ok := previous.(*storedValue); !loaded || !ok || old.isExpired(time.Now()) {
+		notifyKeyspaceEvent(notifyNew, "new", key, db)
+	}
+}
+
+// removeKey removes key from the database without checking whether it exists
+// or has expired.
+func (db *database) removeKey(key string) {
+	ks := db.keys.Load()
+	if _, loaded := ks.entries.LoadAndDelete(key); loaded {
+		ks.forget(key)
+	}
+	signalModifiedKey(db, key)
+}
+
+// deleteKey removes key from the database.
+//
+// Returns:
+//   - The removed value and true if a live key was deleted
+//   - nil and false if the key did not exist or had already expired
+func (db *database) deleteKey(key string) (*storedValue, bool) {
+	sv, ok := db.lookupKey(key)
+	if !ok {
+		return nil, false
+	}
+
+	db.removeKey(key)
+	return sv, true
+}
+
+// rangeKeys calls fn for every key in the database, including keys that have
+// expired but were not removed yet. Iteration stops when fn returns false.
+func (db *database) rangeKeys(fn func(key string) bool) {
+	db.storage().Range(func(key, value interface{}) bool {
+		return fn(key.(string))
+	})
+}
+
+// flush removes every key from the database by replacing its map. The old map
+// is left to the garbage collector, which frees it off the command path, so
+// there is nothing left for FLUSHDB ASYNC to do in the background.
+func (db *database) flush() {
+	touchWatchedKeysInDB(db, nil)
+	db.keys.Store(newKeyspace())
+}
+
+// swapDatabases exchanges the contents of two databases. Connections that
+// selected one of them see the other's keys from now on. Like every command,
+// SWAPDB runs under commandLock, so no other swap or write interleaves.
+func swapDatabases(a, b *database) {
+	touchWatchedKeysInDB(a, b)
+	touchWatchedKeysInDB(b, a)
+
+	aKeys := a.keys.Load()
+	a.keys.Store(b.keys.Load())
+	b.keys.Store(aKeys)
+}
+
+// isExpired reports whether the value has an expiration time that lies before now.
+func (sv *storedValue) isExpired(now time.Time) bool {
+	return !sv.expiresAt.IsZero() && now.After(sv.expiresAt)
+}
+
+// typeName returns the name of the value's type as reported by TYPE.
+// Only strings are stored for now.
+func (sv *storedValue) typeName() string {
+	return "string"
+}
@@ -0,0 +1,67 @@
+package main
+
+import "time"
+
+const (
+	// activeExpireInterval is how often the active expiry cycle runs.
+	activeExpireInterval = 100 * time.Millisecond
+	// activeExpireSampleSize is the number of keys checked per database and round.
+	activeExpireSampleSize = 20
+	// activeExpireTimeLimit bounds the time spent by one cycle, since it holds
+	// commandLock and delays every client while running.
+	activeExpireTimeLimit = 25 * time.Millisecond
+)
+
+// activeExpireCycle periodically removes expired keys that nobody accesses, so
+// that they do not linger in memory and their "expired" keyspace notifications
+// are emitted close to their expiry time. Keys are otherwise only removed
+// lazily, when a command looks them up.
+func activeExpireCycle() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		commandLock.Lock()
+		deadline := time.Now().Add(activeExpireTimeLimit)
+		for _, db := range databases {
+			// Keep sampling the database while more than a quarter of the
+			// sampled keys turn out to be expired, as Redis does.
+			for time.Now().Before(deadline) {
+				sampled, expired := activeExpireSample(db)
+				if sampled == 0 || expired*4 <= sampled {
+					break
+				}
+			}
+		}
+		commandLock.Unlock()
+	}
+}
+
+// activeExpireSample checks up to activeExpireSampleSize of the keys of db that
+// have an expiry, removing the expired ones, and returns how many keys were
+// checked and removed. The Go runtime starts every range over a map at a random
+// position, so every call looks at a different sample of the expires set.
+func activeExpireSample(db *database) (sampled, expired int) {
+	now := time.Now()
+	ks := db.keys.Load()
+
+	var candidates []string
+	for key := range ks.expires {
+		candidates = append(candidates, key)
+		if len(candidates) == activeExpireSampleSize {
+			break
+		}
+	}
+
+	for _, key := range candidates {
+		sampled++
+		val, _ := ks.entries.Load(key)
+		if sv, ok := val.(*storedValue); ok && sv.isExpired(now) {
+			// lookupKey removes the key and emits the notifications.
+			db.lookupKey(key)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
@@ -0,0 +1,160 @@
+package main
+
+// globMaxNesting caps the recursion caused by consecutive '*' segments so that a
+// pathological pattern cannot blow up the matching time.
+const globMaxNesting = 1000
+
+// stringMatch reports whether str matches the glob-style pattern, following the
+// semantics of Redis's stringmatchlen:
+//   - '?' matches any single byte
+//   - '*' matches any sequence of bytes, including the empty one
+//   - '[abc]' matches one of the listed bytes, '[^abc]' any byte not listed
+//   - '[a-z]' matches a range of bytes (inside brackets, ranges can be combined)
+//   - '\x' matches the byte x literally
+//
+// Parameters:
+//   - pattern: The glob pattern (e.g. "user:*", "h?llo", "h[^e]llo")
+//   - str: The string to test
+//   - nocase: Whether letters should be compared case-insensitively
+//
+// Example:
+//
+//	stringMatch("h[a-e]llo", "hello", false) → true
+//	stringMatch("h\\*llo", "hello", false) → false
+func stringMatch(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatchImpl(pattern, str, nocase, &skipLongerMatches, 0)
+}
+
+func stringMatchImpl(pattern, str string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	// Protection against abusive patterns.
+	if nesting > globMaxNesting {
+		return false
+	}
+
+	if len(str) == 0 {
+		// Only stars can match an empty string.
+		for len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+		}
+	}
+
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars, they match the same as a single one.
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true // A trailing star matches the rest of the string.
+			}
+			for len(str) > 0 {
+				if stringMatchImpl(pattern[1:], str, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					// The rest of the pattern failed against a shorter suffix
+					// already, so it cannot match a longer one either.
+					return false
+				}
+				str = str[1:]
+			}
+			// There was no match for the rest of the pattern starting from
+			// anywhere in the string, so there is no point in trying again with
+			// the outer stars either.
+			*skipLongerMatches = true
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for {
+				if len(pattern) == 0 {
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					c := str[0]
+					if nocase {
+						start, end, c = toLowerByte(start), toLowerByte(end), toLowerByte(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[0], str[0], nocase) {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			// An unterminated class is treated as if it were closed at the end
+			// of the pattern; keep the ']' (or nothing) so the loop below skips it.
+			if len(pattern) == 0 {
+				pattern = "]"
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			if !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		default:
+			if !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+		if len(str) == 0 {
+			// Only stars can match the empty remainder of the string.
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+
+	return len(pattern) == 0 && len(str) == 0
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLowerByte(a) == toLowerByte(b)
+	}
+	return a == b
+}
+
+func toLowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		want    bool
+	}{
+		// Literals
+		{"hello", "hello", false, true},
+		{"hello", "hell", false, false},
+		{"hello", "hello!", false, false},
+		{"", "", false, true},
+		{"", "a", false, false},
+
+		// '*'
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"user:*", "user:1", false, true},
+		{"user:*", "users:1", false, false},
+		{"*:1", "user:1", false, true},
+		{"a*b*c", "axxbyyc", false, true},
+		{"a*b*c", "axxbyy", false, false},
+		{"a***b", "ab", false, true},
+		{"a*", "a", false, true},
+
+		// '?'
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"?", "", false, false},
+		{"??", "ab", false, true},
+
+		// Classes and ranges
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[a-e]llo", "hdllo", false, true},
+		{"h[a-e]llo", "hfllo", false, false},
+		{"h[e-a]llo", "hcllo", false, true},
+		{"[a-cx-z]", "y", false, true},
+		{"[a-cx-z]", "m", false, false},
+
+		// Negated classes
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"[^a-c]", "d", false, true},
+		{"[^a-c]", "b", false, false},
+
+		// Escapes
+		{`h\*llo`, "h*llo", false, true},
+		{`h\*llo`, "hello", false, false},
+		{`\?`, "?", false, true},
+		{`\?`, "a", false, false},
+		{`[\]]`, "]", false, true},
+		{`[\^a]`, "^", false, true},
+
+		// An unterminated '[' is closed at the end of the pattern
+		{"[abc", "a", false, true},
+		{"[abc", "d", false, false},
+		{"a[", "a", false, false},
+		{"[^", "a", false, true},
+
+		// A trailing '\' matches itself
+		{`a\`, `a\`, false, true},
+		{`a\`, "a", false, false},
+
+		// nocase
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"h[A-C]llo", "hbllo", true, true},
+		{"h[A-C]llo", "hbllo", false, false},
+		{"h[^A]llo", "hallo", true, false},
+		{"H?LLO", "hello", true, true},
+
+		// Several stars that cannot match must fail fast, without trying every
+		// way of splitting the string between them.
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false, false},
+		{strings.Repeat("*a", 30) + "*", strings.Repeat("a", 100), false, true},
+		{strings.Repeat("*a", 30) + "*b", strings.Repeat("a", 100), false, false},
+	}
+
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str, tt.nocase); got != tt.want {
+			t.Errorf("stringMatch(%q, %q, %v) = %v, want %v", tt.pattern, tt.str, tt.nocase, got, tt.want)
+		}
+	}
+}
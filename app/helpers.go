@@ -2,17 +2,32 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// handleConnection serves a single client connection. It owns the lifecycle of the
+// connection's client state: it is created here when the connection is accepted
+// and released, closing the connection, once the client goes away.
 func handleConnection(conn net.Conn) {
-	defer conn.Close()
+	c := newClient(conn)
+	defer c.release()
+
+	if protectedModeDenies(conn) {
+		c.out.writeError(protectedModeError)
+		c.out.flush()
+		return
+	}
+
 	reader := bufio.NewReader(conn)
 
 	for {
@@ -22,72 +37,160 @@ func handleConnection(conn net.Conn) {
 				break
 			}
 
+			// The client cannot be trusted to send a well-formed request next,
+			// so it gets the error and the connection is closed, as Redis does.
+			var protoErr protocolError
+			if errors.As(err, &protoErr) {
+				c.out.writeError("ERR Protocol error: " + protoErr.msg)
+				c.out.flush()
+				break
+			}
+
+			if err == errQueryBufferLimit {
+				serverStats.queryBufferLimitDisconnections.Add(1)
+			}
+
+			// Any other error leaves the reader in the middle of a frame, so
+			// there is no way to find the start of the next request.
 			fmt.Println("Error parsing command: ", err)
+			break
+		}
+
+		// Empty requests (a bare newline, "*0" or "*-1") are ignored.
+		if command == "" {
+			continue
+		}
+
+		handleCommand(c, command, args)
+
+		// Replies to pipelined commands are accumulated and sent together once
+		// every request already received has been served, saving a write per
+		// command. Replies larger than the buffer still stream out as they grow.
+		if reader.Buffered() > 0 && !c.hasFlag(flagCloseAfterReply) {
 			continue
 		}
 
-		response := handleCommand(command, args)
-		if _, err := conn.Write([]byte(response)); err != nil {
+		if err := c.out.flush(); err != nil {
 			fmt.Println("Error writing response: ", err)
 			break
 		}
+
+		if c.hasFlag(flagCloseAfterReply) {
+			break
+		}
 	}
 }
 
+// maxInlineLen is the longest line accepted for an inline command or a RESP
+// header, including its line terminator.
+const maxInlineLen = 64 * 1024
+
+// errQueryBufferLimit is returned when a request grows beyond the
+// client-query-buffer-limit setting. The connection is closed without a reply.
+var errQueryBufferLimit = errors.New("closing client that reached max query buffer length")
+
+// errLineTooLong is returned by readLine for lines longer than maxInlineLen.
+var errLineTooLong = errors.New("line too long")
+
+// protocolError reports a request that does not follow the protocol. The client
+// receives "-ERR Protocol error: <msg>" and its connection is closed.
+type protocolError struct {
+	msg string
+}
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
 // parseCommandFromRESP parses a Redis RESP protocol message and returns the command + arguments
 // RESP protocol reference: https://redis.io/docs/reference/protocol-spec/
+//
+// Requests that do not start with '*' are parsed as inline commands, see
+// parseInlineCommand.
+//
+// Sizes announced by the client are checked against the
+// proto-max-multibulk-len, proto-max-bulk-len and client-query-buffer-limit
+// settings before anything is allocated for them.
 func parseRESPCommand(reader *bufio.Reader) (string, []string, error) {
 	// RESP commands come in array format: *<number-of-elements>\r\n<elements...>
 	// Example: *2\r\n$4\r\nECHO\r\n$3\r\nhey\r\n → ["ECHO", "hey"]
 
-	// Read the first line which should be the array header
-	headerLine, _, err := reader.ReadLine()
+	first, err := reader.Peek(1)
 	if err != nil {
 		return "", nil, err
 	}
+	if first[0] != '*' {
+		return parseInlineCommand(reader)
+	}
 
-	// Array header must start with '*' and contain the number of elements
-	if len(headerLine) == 0 || headerLine[0] != '*' {
-		return "", nil, fmt.Errorf("invalid RESP array header")
+	// Read the first line which should be the array header
+	headerLine, err := readRESPLine(reader, "too big mbulk count string")
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Convert the array size from string to integer (e.g., "*2" → 2)
-	arraySize, err := parseRESPInteger(string(headerLine[1:]), 1, "invalid array size: %q (must be ≥1)")
+	// Convert the array size from string to integer (e.g., "*2" → 2).
+	// Empty and null arrays hold no command and are skipped.
+	arraySize, err := parseRESPInteger(headerLine[1:], -1, "invalid multibulk length")
 	if err != nil {
 		return "", nil, err
 	}
+	if arraySize <= 0 {
+		return "", nil, nil
+	}
+	if int64(arraySize) > config.protoMaxMultibulkLen.Load() {
+		return "", nil, protocolError{"invalid multibulk length"}
+	}
 
 	var command string
 	var args []string
 
+	// Running size of the request, checked against client-query-buffer-limit
+	requestSize := int64(len(headerLine) + 2)
+	queryBufferLimit := config.clientQueryBufferLimit.Load()
+	maxBulkLen := config.protoMaxBulkLen.Load()
+
 	// Process each element in the array
 	for i := 0; i < arraySize; i++ {
 		// Read bulk string header: $<length>\r\n
-		bulkHeader, _, err := reader.ReadLine()
+		bulkHeader, err := readRESPLine(reader, "too big bulk count string")
 		if err != nil {
 			return "", nil, err
 		}
 
 		// Bulk strings must start with '$' followed by their length
 		if len(bulkHeader) < 1 || bulkHeader[0] != '$' {
-			return "", nil, fmt.Errorf("invalid bulk string header")
+			got := "\\r"
+			if len(bulkHeader) > 0 {
+				got = bulkHeader[:1]
+			}
+			return "", nil, protocolError{fmt.Sprintf("expected '$', got '%s'", got)}
 		}
 
-		// Convert length from string to integer (e.g., "$4" → 4)
-		strLength, err := parseRESPInteger(string(bulkHeader[1:]), 0, "invalid bulk string length: %q (must be ≥0)")
+		// Convert length from string to integer (e.g., "$4" → 4). Null bulk
+		// strings ("$-1") cannot be command arguments.
+		strLength, err := parseRESPInteger(bulkHeader[1:], 0, "invalid bulk length")
 		if err != nil {
 			return "", nil, err
 		}
+		if int64(strLength) > maxBulkLen {
+			return "", nil, protocolError{"invalid bulk length"}
+		}
 
-		// Read exactly the specified number of bytes for the string content
-		strBytes := make([]byte, strLength)
-		_, err = io.ReadFull(reader, strBytes)
-		if err != nil {
-			return "", nil, err
+		requestSize += int64(len(bulkHeader) + 2 + strLength + 2)
+		if requestSize > queryBufferLimit {
+			return "", nil, errQueryBufferLimit
 		}
 
-		// Discard the trailing \r\n after the bulk string content
-		reader.Discard(2) // Remove the CRLF after the content
+		// Read the string content followed by its CRLF terminator
+		strBytes, err := readBulk(reader, strLength+2)
+		if err != nil {
+			return "", nil, unexpectedEOF(err)
+		}
+		if strBytes[strLength] != '\r' || strBytes[strLength+1] != '\n' {
+			return "", nil, protocolError{"expected CRLF after bulk string"}
+		}
+		strBytes = strBytes[:strLength]
 
 		// On first iteration we get the command name, and after, the arguments
 		if i == 0 {
@@ -100,21 +203,233 @@ func parseRESPCommand(reader *bufio.Reader) (string, []string, error) {
 	return command, args, nil
 }
 
+// readRESPLine reads a line of a RESP frame and returns it without its CRLF
+// terminator.
+//
+// Returns:
+//   - A protocolError with tooLongMessage if the line exceeds maxInlineLen
+//   - A protocolError if the line is not terminated by CRLF
+//   - io.ErrUnexpectedEOF if the connection ends in the middle of the line
+func readRESPLine(reader *bufio.Reader, tooLongMessage string) (string, error) {
+	line, err := readLine(reader)
+	if err == errLineTooLong {
+		return "", protocolError{tooLongMessage}
+	}
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", protocolError{"expected CRLF line terminator"}
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// readLine reads up to and including the next '\n', failing with
+// errLineTooLong as soon as the line exceeds maxInlineLen so that a client
+// cannot make the server buffer an endless line.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineLen {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+
+		switch err {
+		case nil:
+			return string(line), nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return "", err
+		}
+	}
+}
+
+// readBulk reads exactly n bytes. Large bulks are read into a buffer that grows
+// as data arrives, so announcing a length does not by itself make the server
+// allocate that much memory.
+func readBulk(reader *bufio.Reader, n int) ([]byte, error) {
+	const chunkSize = 64 * 1024
+	if n <= chunkSize {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(reader, buf)
+		return buf, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(chunkSize)
+	if _, err := io.CopyN(&buf, reader, int64(n)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for reads that happen in
+// the middle of a frame.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseInlineCommand parses an inline command: a single line of space-separated
+// arguments, as typed by hand in telnet or sent with netcat.
+//
+// Example:
+//
+//	Input: "SET greeting \"hello world\"\r\n"
+//	Output: "SET", ["greeting", "hello world"]
+//
+// Returns:
+//   - An empty command if the line holds no arguments
+//   - A protocolError if the line is too long or has unbalanced quotes
+func parseInlineCommand(reader *bufio.Reader) (string, []string, error) {
+	line, err := readLine(reader)
+	if err == errLineTooLong {
+		return "", nil, protocolError{"too big inline request"}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+	fields, ok := splitInlineArgs(line)
+	if !ok {
+		return "", nil, protocolError{"unbalanced quotes in request"}
+	}
+	if len(fields) == 0 {
+		return "", nil, nil
+	}
+
+	return strings.ToUpper(fields[0]), fields[1:], nil
+}
+
+// splitInlineArgs splits line into arguments like redis-cli and Redis's
+// sdssplitargs do. Arguments are separated by whitespace and may be quoted:
+//   - "double quotes" support the escapes \n, \r, \t, \b, \a, \xHH and \<char>
+//   - 'single quotes' only support \' and keep everything else verbatim
+//
+// A closing quote must be followed by whitespace or the end of the line.
+//
+// Returns:
+//   - The arguments and true on success
+//   - nil and false if a quote is not closed or is followed by another character
+func splitInlineArgs(line string) ([]string, bool) {
+	var args []string
+
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, true
+		}
+
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, false // Unterminated quotes
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					value, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(value))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case line[i] == '"':
+					// The closing quote must be followed by a space or nothing at all.
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, false // Unterminated quotes
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			default:
+				switch {
+				case i == len(line) || isInlineSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDouble = true
+				case line[i] == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func isInlineSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == '\v'
+}
+
+func isHexDigit(b byte) bool {
+	return ('0' <= b && b <= '9') || ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F')
+}
+
 // parseRESPInteger safely converts a RESP protocol integer string to an integer
 // with validation. Used for both array sizes and bulk string lengths.
 //
 // Parameters:
 // - s: The string to convert (e.g. "3" from "*3" or "5" from "$5")
-// - min: Minimum allowed value (array sizes need min=1, bulk strings min=0)
-// - errorFormat: Format string for error message (shows original value)
+// - min: Minimum allowed value (array sizes need min=-1, bulk strings min=0)
+// - errorMessage: Protocol error message reported when validation fails
 //
 // Returns:
 // - Parsed integer value
-// - Error if conversion fails or value < min
-func parseRESPInteger(s string, min int, errorFormat string) (int, error) {
+// - A protocolError if conversion fails or value < min
+func parseRESPInteger(s string, min int, errorMessage string) (int, error) {
 	val, err := strconv.Atoi(s)
 	if err != nil || val < min {
-		return 0, fmt.Errorf(errorFormat, s)
+		return 0, protocolError{errorMessage}
 	}
 
 	return val, nil
@@ -124,32 +439,67 @@ func parseRESPInteger(s string, min int, errorFormat string) (int, error) {
 // Arguments format: [key, value, "PX", milliseconds]
 // Returns:
 // - expiresAt: Time when the key should expire
-// - errorResponse: RESP protocol error string if validation fails
-func parseCommandExpiry(args []string) (expiresAt time.Time, errorResponse string) {
+// - errorMessage: RESP protocol error message if validation fails
+func parseCommandExpiry(args []string) (expiresAt time.Time, errorMessage string) {
 	// Validate we have exactly 4 arguments (key, value, option, time)
 	if len(args) != 4 {
 		return time.Time{},
-			"-ERR wrong number of arguments for SET with expiry\r\n"
+			"ERR wrong number of arguments for SET with expiry"
 	}
 
 	// Validate option is PX (case-insensitive)
 	option := strings.ToUpper(args[2])
 	if option != "PX" {
 		return time.Time{},
-			"-ERR unsupported option\r\n"
+			"ERR unsupported option"
 	}
 
 	// Parse milliseconds value
 	ms, err := strconv.ParseInt(args[3], 10, 64)
 	if err != nil || ms <= 0 {
 		return time.Time{},
-			"-ERR invalid expiry time\r\n"
+			"ERR invalid expiry time"
 	}
 
 	// Calculate absolute expiration time
 	return time.Now().Add(time.Duration(ms) * time.Millisecond), ""
 }
 
+// writeFileAtomic replaces the file at path with data. The data is written to a
+// temporary file in the same directory first and renamed over path, so readers
+// and crashes never see a partially written file. The new file keeps the mode
+// of the file it replaces, or gets 0644 if path did not exist.
+func writeFileAtomic(path, data string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
 // readByte reads a single byte from the provided io.Reader.
 //
 // Parameters:
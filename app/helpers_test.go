@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConn counts the writes made to the underlying connection, i.e. the
+// write syscalls issued to send replies.
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+// BenchmarkPipeline sends batches of pipelined PING commands over a loopback TCP
+// connection and reports the throughput along with the number of writes the
+// server made per command. Replies are flushed once per drained pipeline, so
+// writes/cmd falls from 1 at depth 1 to about 1/depth for deeper pipelines.
+//
+// Run with: go test -run '^$' -bench Pipeline ./app
+func BenchmarkPipeline(b *testing.B) {
+	if databases == nil {
+		initDatabases(16)
+	}
+
+	for _, depth := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+
+			var writes atomic.Int64
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				handleConnection(countingConn{conn, &writes})
+			}()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			request := []byte(strings.Repeat("*1\r\n$4\r\nPING\r\n", depth))
+			reply := make([]byte, len("+PONG\r\n")*depth)
+			reader := bufio.NewReader(conn)
+
+			b.SetBytes(int64(len(request)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(request); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(reader, reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.N*depth)/b.Elapsed().Seconds(), "cmds/s")
+			b.ReportMetric(float64(writes.Load())/float64(b.N*depth), "writes/cmd")
+		})
+	}
+}
+
+func TestSplitInlineArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"   \t ", nil, true},
+		{"set a b", []string{"set", "a", "b"}, true},
+		{"  set \t a  ", []string{"set", "a"}, true},
+		{`""`, []string{""}, true},
+		{`"hello world"`, []string{"hello world"}, true},
+		{`a"b c"`, []string{"ab c"}, true},
+
+		// Double quotes
+		{`"\x41\x4a\x7e"`, []string{"AJ~"}, true},
+		{`"\x4g"`, []string{"x4g"}, true},
+		{`"\x4"`, []string{"x4"}, true},
+		{`"a\nb\r\tc\b\a"`, []string{"a\nb\r\tc\b\a"}, true},
+		{`"say \"hi\" \\o/"`, []string{`say "hi" \o/`}, true},
+
+		// Single quotes only unescape \'
+		{`'it\'s'`, []string{"it's"}, true},
+		{`'a\nb "c"'`, []string{`a\nb "c"`}, true},
+
+		// Unbalanced quotes
+		{`"abc`, nil, false},
+		{`'abc`, nil, false},
+		{`"abc\"`, nil, false},
+		{`set "a b`, nil, false},
+
+		// A closing quote must be followed by a space or the end of the line
+		{`"abc"def`, nil, false},
+		{`'abc'def`, nil, false},
+		{`"abc" def`, []string{"abc", "def"}, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := splitInlineArgs(tt.line)
+		if ok != tt.ok || !equalStrings(got, tt.want) {
+			t.Errorf("splitInlineArgs(%q) = %q, %v, want %q, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseInlineCommand(t *testing.T) {
+	tests := []struct {
+		input   string
+		command string
+		args    []string
+		err     string
+	}{
+		{"PING\r\n", "PING", nil, ""},
+		{"set a b\n", "SET", []string{"a", "b"}, ""},
+		{"echo \"hello world\"\r\n", "ECHO", []string{"hello world"}, ""},
+		{"\r\n", "", nil, ""},
+		{"set \"a\r\n", "", nil, "Protocol error: unbalanced quotes in request"},
+		{"echo \"a\"b\r\n", "", nil, "Protocol error: unbalanced quotes in request"},
+		{strings.Repeat("a", maxInlineLen+1) + "\r\n", "", nil, "Protocol error: too big inline request"},
+		{"PING", "", nil, "EOF"},
+	}
+
+	for _, tt := range tests {
+		command, args, err := parseRESPCommand(bufio.NewReader(strings.NewReader(tt.input)))
+		if got := errorText(err); got != tt.err {
+			t.Errorf("parsing %.40q: error %q, want %q", tt.input, got, tt.err)
+			continue
+		}
+		if command != tt.command || !equalStrings(args, tt.args) {
+			t.Errorf("parsing %.40q: got %q %q, want %q %q", tt.input, command, args, tt.command, tt.args)
+		}
+	}
+}
+
+func TestParseRESPCommand(t *testing.T) {
+	queryBufferLimit := config.clientQueryBufferLimit.Load()
+	defer config.clientQueryBufferLimit.Store(queryBufferLimit)
+	config.clientQueryBufferLimit.Store(64)
+
+	tests := []struct {
+		input   string
+		command string
+		args    []string
+		err     string
+	}{
+		{"*2\r\n$4\r\necho\r\n$3\r\nhey\r\n", "ECHO", []string{"hey"}, ""},
+		{"*2\r\n$3\r\nGET\r\n$0\r\n\r\n", "GET", []string{""}, ""},
+		{"*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", "ECHO", []string{"a\r\nb"}, ""},
+		{"*0\r\n", "", nil, ""},
+		{"*-1\r\n", "", nil, ""},
+
+		// Multibulk length
+		{"*-2\r\n", "", nil, "Protocol error: invalid multibulk length"},
+		{"*abc\r\n", "", nil, "Protocol error: invalid multibulk length"},
+		{"*\r\n", "", nil, "Protocol error: invalid multibulk length"},
+		{"*1048577\r\n", "", nil, "Protocol error: invalid multibulk length"},
+		{"*" + strings.Repeat("1", maxInlineLen) + "\r\n", "", nil, "Protocol error: too big mbulk count string"},
+
+		// Line terminators
+		{"*1\n$4\r\nPING\r\n", "", nil, "Protocol error: expected CRLF line terminator"},
+		{"*1\r\n$4\nPING\r\n", "", nil, "Protocol error: expected CRLF line terminator"},
+		{"*1\r\n$4\r\nPINGxx", "", nil, "Protocol error: expected CRLF after bulk string"},
+		{"*1\r\n$4\r\nPING\n", "", nil, "unexpected EOF"},
+		{"*1\r\n$4\r\nPING\nx", "", nil, "Protocol error: expected CRLF after bulk string"},
+
+		// Bulk headers
+		{"*1\r\n:4\r\n", "", nil, "Protocol error: expected '$', got ':'"},
+		{"*1\r\n\r\n", "", nil, `Protocol error: expected '$', got '\r'`},
+		{"*1\r\n$-1\r\n", "", nil, "Protocol error: invalid bulk length"},
+		{"*1\r\n$x\r\n", "", nil, "Protocol error: invalid bulk length"},
+		{"*1\r\n$536870913\r\n", "", nil, "Protocol error: invalid bulk length"},
+		{"*1\r\n$65\r\n", "", nil, errQueryBufferLimit.Error()},
+
+		// Truncated frames
+		{"*2\r\n$4\r\nECHO\r\n", "", nil, "unexpected EOF"},
+		{"*1\r\n$4\r\nPI", "", nil, "unexpected EOF"},
+		{"*1\r\n$4", "", nil, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		command, args, err := parseRESPCommand(bufio.NewReader(strings.NewReader(tt.input)))
+		if got := errorText(err); got != tt.err {
+			t.Errorf("parsing %.40q: error %q, want %q", tt.input, got, tt.err)
+			continue
+		}
+		if command != tt.command || !equalStrings(args, tt.args) {
+			t.Errorf("parsing %.40q: got %q %q, want %q %q", tt.input, command, args, tt.command, tt.args)
+		}
+	}
+}
+
+// errorText returns the message of err, or "" if err is nil.
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// serverStart is when the server started, reported as its uptime.
+var serverStart = time.Now()
+
+// serverStats holds the counters reported in the stats section of INFO.
+var serverStats struct {
+	connectionsReceived             atomic.Int64 // Connections accepted since startup
+	commandsProcessed               atomic.Int64 // Commands executed since startup
+	queryBufferLimitDisconnections  atomic.Int64 // Clients dropped by client-query-buffer-limit
+	outputBufferLimitDisconnections atomic.Int64 // Clients dropped by client-output-buffer-limit
+}
+
+// infoSection is a section of the INFO reply. fields returns its "name:value"
+// lines.
+type infoSection struct {
+	name   string
+	fields func() []string
+}
+
+// infoSections lists the INFO sections in the order they are returned.
+var infoSections = []infoSection{
+	{"server", serverInfo},
+	{"clients", clientsInfo},
+	{"stats", statsInfo},
+	{"keyspace", keyspaceInfo},
+}
+
+// infoCommand handles the INFO [section ...] command which returns information
+// and statistics about the server. Without arguments, or with "all", "default"
+// or "everything", every section is returned.
+//
+// Example:
+//
+//	Input: ["stats"]
+//	Output: "$...\r\n# Stats\r\ntotal_connections_received:1\r\n...\r\n"
+func infoCommand(c *client, args []string) {
+	all := len(args) == 0
+	wanted := map[string]bool{}
+	for _, arg := range args {
+		switch name := strings.ToLower(arg); name {
+		case "all", "default", "everything":
+			all = true
+		default:
+			wanted[name] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		for _, field := range section.fields() {
+			b.WriteString(field)
+			b.WriteString("\r\n")
+		}
+	}
+
+	c.out.writeVerbatim("txt", b.String())
+}
+
+func serverInfo() []string {
+	uptime := int64(time.Since(serverStart).Seconds())
+
+	return []string{
+		"redis_version:" + redisVersion,
+		"redis_mode:standalone",
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"tcp_port:" + strconv.Itoa(config.port),
+		"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+		"uptime_in_days:" + strconv.FormatInt(uptime/86400, 10),
+		"config_file:" + config.configfile,
+	}
+}
+
+func clientsInfo() []string {
+	connected, pubsub := 0, 0
+	clients.Range(func(key, value interface{}) bool {
+		connected++
+		if value.(*client).isSubscribed() {
+			pubsub++
+		}
+		return true
+	})
+
+	return []string{
+		"connected_clients:" + strconv.Itoa(connected),
+		"pubsub_clients:" + strconv.Itoa(pubsub),
+	}
+}
+
+func statsInfo() []string {
+	return []string{
+		"total_connections_received:" + strconv.FormatInt(serverStats.connectionsReceived.Load(), 10),
+		"total_commands_processed:" + strconv.FormatInt(serverStats.commandsProcessed.Load(), 10),
+		"pubsub_channels:" + strconv.Itoa(len(pubsubChannels)),
+		"pubsub_patterns:" + strconv.Itoa(len(pubsubPatterns)),
+		"pubsubshard_channels:" + strconv.Itoa(len(pubsubShardChannels)),
+		"client_query_buffer_limit_disconnections:" + strconv.FormatInt(serverStats.queryBufferLimitDisconnections.Load(), 10),
+		"client_output_buffer_limit_disconnections:" + strconv.FormatInt(serverStats.outputBufferLimitDisconnections.Load(), 10),
+	}
+}
+
+// resetServerStats zeroes the counters of the stats section, as CONFIG RESETSTAT
+// does.
+func resetServerStats() {
+	serverStats.connectionsReceived.Store(0)
+	serverStats.commandsProcessed.Store(0)
+	serverStats.queryBufferLimitDisconnections.Store(0)
+	serverStats.outputBufferLimitDisconnections.Store(0)
+}
+
+// keyspaceInfo reports the number of keys and of keys with an expiry of every
+// non-empty database, e.g. "db0:keys=2,expires=1,avg_ttl=0". Like DBSIZE, the
+// counts include keys that expired but were not removed yet.
+func keyspaceInfo() []string {
+	var fields []string
+	for _, db := range databases {
+		ks := db.keys.Load()
+		if ks.scan.size > 0 {
+			fields = append(fields, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0", db.id, ks.scan.size, len(ks.expires)))
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// delCommand handles the DEL and UNLINK commands which remove the given keys.
+// A key given more than once is only counted the first time it is removed.
+// UNLINK needs no background work: the memory of a removed value is reclaimed
+// by the garbage collector, not by the command.
+//
+// Example:
+//
+//	Input: ["foo", "bar", "foo"]
+//	Output: ":2\r\n" (if both "foo" and "bar" existed)
+func delCommand(c *client, args []string) {
+	deleted := 0
+	for _, key := range args {
+		if _, ok := c.db.deleteKey(key); ok {
+			notifyKeyspaceEvent(notifyGeneric, "del", key, c.db)
+			deleted++
+		}
+	}
+
+	c.out.writeInteger(int64(deleted))
+}
+
+// existsCommand handles the EXISTS command which counts how many of the given
+// keys exist. Keys given more than once are counted every time.
+//
+// Example:
+//
+//	Input: ["foo", "foo", "nosuchkey"]
+//	Output: ":2\r\n" (if "foo" exists)
+func existsCommand(c *client, args []string) {
+	count := 0
+	for _, key := range args {
+		if _, ok := c.db.lookupKey(key); ok {
+			count++
+		}
+	}
+
+	c.out.writeInteger(int64(count))
+}
+
+// touchCommand handles the TOUCH command which returns the number of the given
+// keys that exist, expiring the ones whose TTL has elapsed along the way.
+func touchCommand(c *client, args []string) {
+	touched := 0
+	for _, key := range args {
+		if _, ok := c.db.lookupKey(key); ok {
+			touched++
+		}
+	}
+
+	c.out.writeInteger(int64(touched))
+}
+
+// renameCommand handles the RENAME command which moves the value (and its TTL)
+// from args[0] to args[1], overwriting the destination.
+func renameCommand(c *client, args []string) {
+	if _, ok := renameKey(c.db, args[0], args[1], false); !ok {
+		c.out.writeError("ERR no such key")
+		return
+	}
+
+	c.out.writeSimpleString("OK")
+}
+
+// renamenxCommand handles the RENAMENX command which renames args[0] to args[1]
+// only if the destination does not exist yet.
+//
+// Returns:
+//   - ":1\r\n" if the key was renamed
+//   - ":0\r\n" if the destination already exists
+//   - "-ERR no such key\r\n" if the source does not exist
+func renamenxCommand(c *client, args []string) {
+	renamed, ok := renameKey(c.db, args[0], args[1], true)
+	if !ok {
+		c.out.writeError("ERR no such key")
+		return
+	}
+	if !renamed {
+		c.out.writeInteger(0)
+		return
+	}
+
+	c.out.writeInteger(1)
+}
+
+// renameKey moves the value stored at src to dst, keeping its expiration time.
+//
+// Returns:
+//   - renamed: Whether the value was moved
+//   - found: Whether src exists
+func renameKey(db *database, src, dst string, nx bool) (renamed bool, found bool) {
+	sv, ok := db.lookupKey(src)
+	if !ok {
+		return false, false
+	}
+
+	if src == dst {
+		return !nx, true
+	}
+
+	if _, exists := db.lookupKey(dst); exists && nx {
+		return false, true
+	}
+
+	db.removeKey(src)
+	notifyKeyspaceEvent(notifyGeneric, "rename_from", src, db)
+	db.setKey(dst, sv)
+	notifyKeyspaceEvent(notifyGeneric, "rename_to", dst, db)
+	return true, true
+}
+
+// copyCommand handles the COPY command: COPY source destination [DB destination-db] [REPLACE]
+//
+// Returns:
+//   - ":1\r\n" if the value was copied
+//   - ":0\r\n" if the source does not exist, or the destination exists and REPLACE was not given
+func copyCommand(c *client, args []string) {
+	src, dst := args[0], args[1]
+	replace := false
+	dstDB := c.db
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				c.out.writeError("ERR syntax error")
+				return
+			}
+			index, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				c.out.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			db, ok := getDatabase(index)
+			if !ok {
+				c.out.writeError("ERR DB index is out of range")
+				return
+			}
+			dstDB = db
+			i++
+		default:
+			c.out.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	if src == dst && dstDB == c.db {
+		c.out.writeError("ERR source and destination objects are the same")
+		return
+	}
+
+	sv, ok := c.db.lookupKey(src)
+	if !ok {
+		c.out.writeInteger(0)
+		return
+	}
+
+	if _, exists := dstDB.lookupKey(dst); exists && !replace {
+		c.out.writeInteger(0)
+		return
+	}
+
+	dstDB.setKey(dst, &storedValue{
+		value:     sv.value,
+		expiresAt: sv.expiresAt,
+	})
+	notifyKeyspaceEvent(notifyGeneric, "copy_to", dst, dstDB)
+
+	c.out.writeInteger(1)
+}
+
+// randomkeyCommand handles the RANDOMKEY command which returns a random live key,
+// or a null bulk string if the database is empty.
+func randomkeyCommand(c *client, args []string) {
+	_ = args
+
+	// lookupKey removes the expired keys it is given, so every miss shrinks
+	// the table and the loop ends once a live key is picked or none is left.
+	table := c.db.keys.Load().scan
+	for {
+		key, ok := table.randomKey()
+		if !ok {
+			c.out.writeNull()
+			return
+		}
+		if _, ok := c.db.lookupKey(key); ok {
+			c.out.writeBulkString(key)
+			return
+		}
+	}
+}
+
+// dbsizeCommand handles the DBSIZE command which returns the number of keys.
+// Like Redis, it counts the keys that expired but were not removed yet.
+func dbsizeCommand(c *client, args []string) {
+	_ = args
+
+	c.out.writeInteger(int64(c.db.keys.Load().scan.size))
+}
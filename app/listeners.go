@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// bindNetworkAddress returns the network and host to listen on for a bind
+// address: "*" is every IPv4 address and "::*" every IPv6 address.
+//
+// Example:
+//
+//	Input: "::*"
+//	Output: "tcp6", "::"
+func bindNetworkAddress(addr string) (network, host string) {
+	switch addr {
+	case "*":
+		return "tcp4", "0.0.0.0"
+	case "::*":
+		return "tcp6", "::"
+	default:
+		return "tcp", addr
+	}
+}
+
+// listenTCP listens on port on every bind address. Addresses prefixed with '-'
+// are optional: failing to bind them is reported and skipped, like Redis does
+// for the default "-::*" on hosts without IPv6.
+func listenTCP(port int, tlsConfig *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range config.bind {
+		optional := strings.HasPrefix(addr, "-")
+		network, host := bindNetworkAddress(strings.TrimPrefix(addr, "-"))
+
+		l, err := net.Listen(network, net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			if optional {
+				fmt.Printf("DEBUG: Skipping optional bind address %s: %v\n", addr, err)
+				continue
+			}
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to bind %s port %d: %w", host, port, err)
+		}
+
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on the Unix domain socket at path, replacing a stale
+// socket file left by a previous run, and applies unixsocketperm if set.
+func listenUnix(path string) (net.Listener, error) {
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Unix socket %s: %w", path, err)
+	}
+
+	if config.unixsocketperm != 0 {
+		if err := os.Chmod(path, config.unixsocketperm); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set permissions of Unix socket %s: %w", path, err)
+		}
+	}
+	return l, nil
+}
+
+// openListeners opens every configured listener: plain TCP on port, TLS on
+// tls-port and the Unix socket. A port of 0 disables the matching listener.
+func openListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	if config.port != 0 {
+		tcp, err := listenTCP(config.port, nil)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, tcp...)
+	}
+
+	if config.tlsPort != 0 {
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		tl, err := listenTCP(config.tlsPort, tlsConfig)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, tl...)
+	}
+
+	if config.unixsocket != "" {
+		ul, err := listenUnix(config.unixsocket)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, ul)
+	}
+
+	if len(listeners) == 0 {
+		closeAll()
+		return nil, fmt.Errorf("no listener is configured: set port, tls-port or unixsocket")
+	}
+	return listeners, nil
+}
+
+// clientAddresses returns the remote and local addresses of conn as CLIENT LIST
+// shows them. Connections over the Unix socket report the socket path.
+func clientAddresses(conn net.Conn) (addr, laddr string) {
+	if ua, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		path := ua.Name + ":0"
+		return path, path
+	}
+	return conn.RemoteAddr().String(), conn.LocalAddr().String()
+}
@@ -0,0 +1,97 @@
+package main
+
+// isTransactionCommand reports whether a command controls the transaction itself
+// and must run immediately instead of being queued after MULTI.
+func isTransactionCommand(name string) bool {
+	switch name {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "QUIT":
+		return true
+	default:
+		return false
+	}
+}
+
+// flagTransactionError makes the transaction c is queuing fail at EXEC, after
+// one of its commands was rejected. It does nothing outside MULTI.
+func flagTransactionError(c *client) {
+	if c.hasFlag(flagMulti) {
+		c.flags |= flagDirtyExec
+	}
+}
+
+// multiCommand handles the MULTI command which starts queuing the following
+// commands of the connection until EXEC or DISCARD.
+func multiCommand(c *client, args []string) {
+	_ = args
+	if c.hasFlag(flagMulti) {
+		c.out.writeError("ERR MULTI calls can not be nested")
+		return
+	}
+
+	c.flags |= flagMulti
+	c.out.writeSimpleString("OK")
+}
+
+// execCommand handles the EXEC command which runs every queued command and
+// returns their replies as an array. It runs with commandLock held (see
+// handleCommand), so no other client's command can interleave with the
+// transaction.
+//
+// Returns:
+//   - "*<n>\r\n<reply 1>...<reply n>" with the reply of each queued command
+//   - "*-1\r\n" (null array, "_\r\n" in RESP3) if a watched key was modified since WATCH
+//   - "-EXECABORT ..." if a command was rejected while being queued
+//   - "-ERR EXEC without MULTI" if no transaction was started
+func execCommand(c *client, args []string) {
+	_ = args
+	if !c.hasFlag(flagMulti) {
+		c.out.writeError("ERR EXEC without MULTI")
+		return
+	}
+
+	queue := c.queue
+	dirty := c.hasFlag(flagDirtyExec)
+	modified := watchedKeysModified(c)
+	discardTransaction(c)
+
+	if dirty {
+		c.out.writeError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	if modified {
+		c.out.writeNullArray()
+		return
+	}
+
+	c.out.writeArrayLen(len(queue))
+	for _, queued := range queue {
+		// ACL rules may have changed since the command was queued.
+		if denial := aclCheckCommand(c.user, queued.name, registry[queued.name], queued.args); denial.reason != aclAllowed {
+			logACLDenial(c, denial, "multi")
+			c.out.writeError("NOPERM " + denial.message())
+			continue
+		}
+		queued.handler(c, queued.args)
+	}
+}
+
+// discardCommand handles the DISCARD command which drops the queued commands
+// and leaves the transaction.
+func discardCommand(c *client, args []string) {
+	_ = args
+	if !c.hasFlag(flagMulti) {
+		c.out.writeError("ERR DISCARD without MULTI")
+		return
+	}
+
+	discardTransaction(c)
+	c.out.writeSimpleString("OK")
+}
+
+// discardTransaction resets the transaction state of the client, including the
+// keys it watches.
+func discardTransaction(c *client) {
+	unwatchAllKeys(c)
+	c.queue = nil
+	c.flags &^= flagMulti | flagDirtyExec
+}
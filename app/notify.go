@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace event classes, selected with the notify-keyspace-events parameter.
+const (
+	notifyKeyspace = 1 << iota // K: __keyspace@<db>__:<key> notifications
+	notifyKeyevent             // E: __keyevent@<db>__:<event> notifications
+	notifyGeneric              // g: generic commands (DEL, RENAME, EXPIRE...)
+	notifyString               // $: string commands
+	notifyList                 // l: list commands
+	notifySet                  // s: set commands
+	notifyHash                 // h: hash commands
+	notifyZset                 // z: sorted set commands
+	notifyExpired              // x: expired events
+	notifyEvicted              // e: evicted events
+	notifyStream               // t: stream commands
+	notifyNew                  // n: new key events (not included in 'A')
+
+	// notifyAll is the 'A' alias for "g$lshzxet".
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream
+)
+
+// notifyClassChars maps the characters of notify-keyspace-events to their class,
+// in the order they are written back by CONFIG GET.
+var notifyClassChars = []struct {
+	char  byte
+	class int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZset},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+	{'n', notifyNew},
+}
+
+// parseKeyspaceEvents converts a notify-keyspace-events string such as "KEA" or
+// "Ex" into class flags.
+func parseKeyspaceEvents(s string) (int, error) {
+	flags := 0
+
+chars:
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+
+		for _, c := range notifyClassChars {
+			if c.char == s[i] {
+				flags |= c.class
+				continue chars
+			}
+		}
+
+		return 0, fmt.Errorf("Invalid event class character. Use 'Ag$lshzxetKEn'.")
+	}
+
+	return flags, nil
+}
+
+// formatKeyspaceEvents converts class flags back into their string form.
+func formatKeyspaceEvents(flags int) string {
+	var b strings.Builder
+	if flags&notifyAll == notifyAll {
+		b.WriteByte('A')
+		flags &^= notifyAll
+	}
+
+	for _, c := range notifyClassChars {
+		if flags&c.class != 0 {
+			b.WriteByte(c.char)
+		}
+	}
+
+	return b.String()
+}
+
+// notifyKeyspaceEvent publishes a keyspace notification for event on key in db,
+// if the event's class is enabled by notify-keyspace-events:
+//   - "__keyspace@<db>__:<key>" receives the event name (when K is enabled)
+//   - "__keyevent@<db>__:<event>" receives the key name (when E is enabled)
+func notifyKeyspaceEvent(class int, event, key string, db *database) {
+	flags := config.notifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+
+	if flags&notifyKeyspace != 0 {
+		publish(fmt.Sprintf("__keyspace@%d__:%s", db.id, key), event)
+	}
+	if flags&notifyKeyevent != 0 {
+		publish(fmt.Sprintf("__keyevent@%d__:%s", db.id, event), key)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// outputBufferClass selects which client-output-buffer-limit applies to a client.
+type outputBufferClass int
+
+const (
+	classNormal  outputBufferClass = iota // Regular clients
+	classReplica                          // Replicas (none connect for now)
+	classPubSub                           // Clients subscribed to a channel, pattern or shard channel
+)
+
+// outputBufferClassNames are the names of the classes in CONFIG GET/SET, indexed
+// by class. "replica" is accepted as an alias of "slave" when setting.
+var outputBufferClassNames = []string{"normal", "slave", "pubsub"}
+
+// outputBufferLimit caps how much output may pile up for a client. A client is
+// disconnected as soon as its output reaches hard bytes, or once it stays at or
+// above soft bytes for more than softSeconds. A zero size disables the limit.
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int64
+}
+
+// outputBufferLimits holds the limit of every class. The whole array is replaced
+// by CONFIG SET, so writers of any connection can read it without a lock.
+var outputBufferLimits atomic.Pointer[[3]outputBufferLimit]
+
+func init() {
+	outputBufferLimits.Store(&[3]outputBufferLimit{
+		classNormal:  {0, 0, 0},
+		classReplica: {256 << 20, 64 << 20, 60},
+		classPubSub:  {32 << 20, 8 << 20, 60},
+	})
+}
+
+// outputBufferLimitFor returns the limit that currently applies to class.
+func outputBufferLimitFor(class outputBufferClass) outputBufferLimit {
+	return outputBufferLimits.Load()[class]
+}
+
+// outputBufferClass returns the limit class of c. Subscribed clients are in the
+// pubsub class until they unsubscribe from everything.
+func (c *client) outputBufferClass() outputBufferClass {
+	if c.isSubscribed() {
+		return classPubSub
+	}
+	return classNormal
+}
+
+// reached reports whether an output buffer of size bytes is over the limit.
+// softSince tracks when the buffer went over the soft limit, and is reset once
+// it goes back under.
+func (l outputBufferLimit) reached(size int64, softSince *time.Time) bool {
+	if l.hard > 0 && size >= l.hard {
+		return true
+	}
+
+	if l.soft == 0 || size < l.soft {
+		*softSince = time.Time{}
+		return false
+	}
+	if softSince.IsZero() {
+		*softSince = time.Now()
+		return false
+	}
+	return time.Since(*softSince) > time.Duration(l.softSeconds)*time.Second
+}
+
+// formatOutputBufferLimits formats the limits the way CONFIG GET reports them,
+// e.g. "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60".
+func formatOutputBufferLimits() string {
+	limits := outputBufferLimits.Load()
+
+	var fields []string
+	for class, limit := range limits {
+		fields = append(fields, outputBufferClassNames[class],
+			strconv.FormatInt(limit.hard, 10),
+			strconv.FormatInt(limit.soft, 10),
+			strconv.FormatInt(limit.softSeconds, 10))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// setOutputBufferLimits parses "<class> <hard> <soft> <soft seconds>" groups and
+// updates the limits of the classes they mention. Sizes accept memory units.
+//
+// Example:
+//
+//	Input: "pubsub 64mb 16mb 90"
+func setOutputBufferLimits(value string) error {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return fmt.Errorf("Wrong number of arguments in buffer limit configuration.")
+	}
+
+	limits := *outputBufferLimits.Load()
+	for i := 0; i < len(fields); i += 4 {
+		class := -1
+		for c, name := range outputBufferClassNames {
+			if strings.EqualFold(fields[i], name) {
+				class = c
+			}
+		}
+		if strings.EqualFold(fields[i], "replica") {
+			class = int(classReplica)
+		}
+		if class < 0 {
+			return fmt.Errorf("Invalid client class specified in buffer limit configuration.")
+		}
+
+		hard, hardErr := parseMemory(fields[i+1])
+		soft, softErr := parseMemory(fields[i+2])
+		seconds, secondsErr := strconv.ParseInt(fields[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || seconds < 0 {
+			return fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+
+		limits[class] = outputBufferLimit{hard, soft, seconds}
+	}
+
+	outputBufferLimits.Store(&limits)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "net"
+
+// protectedModeError is sent to the non-loopback clients that protected mode
+// turns away, before their connection is closed.
+const protectedModeError = "DENIED Redis is running in protected mode because protected mode is enabled and no password is set for the default user. In this mode connections are only accepted from the loopback interface. If you want to connect from external computers to Redis you may adopt one of the following solutions: 1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface by connecting to Redis from the same host the server is running, however MAKE SURE Redis is not publicly accessible from internet if you do so. Use CONFIG REWRITE to make this change permanent. 2) Alternatively you can just disable the protected mode by editing the Redis configuration file, and setting the protected mode option to 'no', and then restarting the server. 3) If you started the server manually just for testing, restart it with the '--protected-mode no' option. 4) Set up an authentication password for the default user. NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside."
+
+// protectedModeDenies reports whether protected mode rejects conn: it is
+// enabled, the default user needs no password, no bind address was configured
+// and the client is not on the loopback interface or the Unix socket.
+func protectedModeDenies(conn net.Conn) bool {
+	commandLock.Lock()
+	defer commandLock.Unlock()
+
+	if !config.protectedMode || !aclUsers["default"].nopass {
+		return false
+	}
+	if lookupConfigParameter("bind").value.get() != configDefaults["bind"] {
+		return false
+	}
+	return !isLocalConn(conn)
+}
+
+// isLocalConn reports whether conn comes from the loopback interface or the
+// Unix socket.
+func isLocalConn(conn net.Conn) bool {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return true
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pubsubChannels maps every channel with subscribers to the clients subscribed
+// to it, and pubsubPatterns and pubsubShardChannels do the same for PSUBSCRIBE
+// patterns and SSUBSCRIBE shard channels. All are guarded by commandLock.
+var (
+	pubsubChannels      = map[string]map[*client]struct{}{}
+	pubsubPatterns      = map[string]map[*client]struct{}{}
+	pubsubShardChannels = map[string]map[*client]struct{}{}
+)
+
+// subscriptionCount returns the number of channels and patterns c is subscribed to,
+// which is the count reported by SUBSCRIBE and PSUBSCRIBE confirmations.
+func (c *client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// isSubscribed reports whether c is in subscribed mode, i.e. subscribed to any
+// channel, pattern or shard channel.
+func (c *client) isSubscribed() bool {
+	return c.subscriptionCount()+len(c.shardChannels) > 0
+}
+
+// isPubSubAllowed reports whether a command may run on a RESP2 connection in
+// subscribed mode. RESP3 connections can run any command, since push frames
+// cannot be confused with replies.
+func isPubSubAllowed(name string) bool {
+	switch name {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "SSUBSCRIBE", "SUNSUBSCRIBE", "PING", "QUIT":
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribeCommand handles the SUBSCRIBE command. The client receives a
+// confirmation for every channel, then a "message" frame for every message
+// published to one of them.
+//
+// Example:
+//
+//	Input: ["news"]
+//	Output: "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+func subscribeCommand(c *client, args []string) {
+	for _, channel := range args {
+		subscribe(pubsubChannels, c.channels, c, channel)
+		writeSubscriptionReply(c, "subscribe", channel, c.subscriptionCount())
+	}
+}
+
+// unsubscribeCommand handles the UNSUBSCRIBE command. Without arguments the
+// client is unsubscribed from every channel.
+func unsubscribeCommand(c *client, args []string) {
+	unsubscribeWithReply(c, "unsubscribe", pubsubChannels, c.channels, args)
+}
+
+// psubscribeCommand handles the PSUBSCRIBE command. Patterns use the same glob
+// syntax as KEYS, and matching messages are delivered as "pmessage" frames.
+func psubscribeCommand(c *client, args []string) {
+	for _, pattern := range args {
+		subscribe(pubsubPatterns, c.patterns, c, pattern)
+		writeSubscriptionReply(c, "psubscribe", pattern, c.subscriptionCount())
+	}
+}
+
+// punsubscribeCommand handles the PUNSUBSCRIBE command. Without arguments the
+// client is unsubscribed from every pattern.
+func punsubscribeCommand(c *client, args []string) {
+	unsubscribeWithReply(c, "punsubscribe", pubsubPatterns, c.patterns, args)
+}
+
+// publishCommand handles the PUBLISH command which delivers a message to every
+// client subscribed to the channel or to a pattern matching it.
+//
+// Returns:
+//   - ":<n>\r\n" with the number of clients that received the message
+func publishCommand(c *client, args []string) {
+	c.out.writeInteger(int64(publish(args[0], args[1])))
+}
+
+// publish delivers message to the subscribers of channel and returns how many
+// clients received it.
+func publish(channel, message string) int {
+	receivers := 0
+
+	if subscribers, ok := pubsubChannels[channel]; ok {
+		for sub := range subscribers {
+			sub.out.writePush("message", channel, message)
+			sub.out.flush()
+			receivers++
+		}
+	}
+
+	for pattern, subscribers := range pubsubPatterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+
+		for sub := range subscribers {
+			sub.out.writePush("pmessage", pattern, channel, message)
+			sub.out.flush()
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// pubsubCommand handles the PUBSUB introspection command:
+//   - PUBSUB CHANNELS [pattern]: active channels, optionally matching pattern
+//   - PUBSUB NUMSUB [channel ...]: subscriber count of each channel
+//   - PUBSUB NUMPAT: number of patterns with subscribers
+//   - PUBSUB SHARDCHANNELS [pattern]: active shard channels, optionally matching pattern
+//   - PUBSUB SHARDNUMSUB [shardchannel ...]: subscriber count of each shard channel
+func pubsubCommand(c *client, args []string) {
+	subCommand := strings.ToUpper(args[0])
+
+	switch {
+	case subCommand == "CHANNELS" && len(args) <= 2:
+		c.out.writeBulkStrings(activeChannels(pubsubChannels, args[1:])...)
+	case subCommand == "SHARDCHANNELS" && len(args) <= 2:
+		c.out.writeBulkStrings(activeChannels(pubsubShardChannels, args[1:])...)
+	case subCommand == "NUMSUB":
+		writeNumsubReply(c, pubsubChannels, args[1:])
+	case subCommand == "SHARDNUMSUB":
+		writeNumsubReply(c, pubsubShardChannels, args[1:])
+	case subCommand == "NUMPAT" && len(args) == 1:
+		c.out.writeInteger(int64(len(pubsubPatterns)))
+	default:
+		c.out.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[0]))
+	}
+}
+
+// writeNumsubReply writes the channel/subscriber count pairs of PUBSUB NUMSUB
+// and PUBSUB SHARDNUMSUB.
+func writeNumsubReply(c *client, registry map[string]map[*client]struct{}, channels []string) {
+	c.out.writeMapLen(len(channels))
+	for _, channel := range channels {
+		c.out.writeBulkString(channel)
+		c.out.writeInteger(int64(len(registry[channel])))
+	}
+}
+
+// activeChannels returns the sorted names of the channels in registry,
+// filtered by the optional glob pattern in args[0].
+func activeChannels(registry map[string]map[*client]struct{}, args []string) []string {
+	channels := []string{}
+	for channel := range registry {
+		if len(args) == 0 || stringMatch(args[0], channel, false) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// subscribe adds c to the subscribers of name in registry, and name to the
+// client's own subscriptions.
+func subscribe(registry map[string]map[*client]struct{}, own map[string]struct{}, c *client, name string) {
+	if _, ok := own[name]; ok {
+		return
+	}
+
+	own[name] = struct{}{}
+	if registry[name] == nil {
+		registry[name] = map[*client]struct{}{}
+	}
+	registry[name][c] = struct{}{}
+}
+
+// unsubscribe removes c from the subscribers of name in registry.
+func unsubscribe(registry map[string]map[*client]struct{}, own map[string]struct{}, c *client, name string) {
+	delete(own, name)
+
+	subscribers := registry[name]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(registry, name)
+	}
+}
+
+// unsubscribeWithReply unsubscribes c from names (or from all its subscriptions
+// in own when names is empty) and writes the confirmation frames. Shard channels
+// are counted apart from channels and patterns, as in the SSUBSCRIBE replies.
+func unsubscribeWithReply(c *client, kind string, registry map[string]map[*client]struct{}, own map[string]struct{}, names []string) {
+	remaining := c.subscriptionCount
+	if kind == "sunsubscribe" {
+		remaining = func() int { return len(c.shardChannels) }
+	}
+
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// Nothing to unsubscribe from: a single frame with a null name is sent.
+		if len(names) == 0 {
+			c.out.writePushLen(3)
+			c.out.writeBulkString(kind)
+			c.out.writeNull()
+			c.out.writeInteger(int64(remaining()))
+			return
+		}
+	}
+
+	for _, name := range names {
+		unsubscribe(registry, own, c, name)
+		writeSubscriptionReply(c, kind, name, remaining())
+	}
+}
+
+// unsubscribeAll drops every subscription of c. It is called when the
+// connection is released.
+func unsubscribeAll(c *client) {
+	for channel := range c.channels {
+		unsubscribe(pubsubChannels, c.channels, c, channel)
+	}
+	for pattern := range c.patterns {
+		unsubscribe(pubsubPatterns, c.patterns, c, pattern)
+	}
+	for channel := range c.shardChannels {
+		unsubscribe(pubsubShardChannels, c.shardChannels, c, channel)
+	}
+}
+
+// writeSubscriptionReply writes a (un)subscription confirmation frame.
+func writeSubscriptionReply(c *client, kind, name string, count int) {
+	c.out.writePushLen(3)
+	c.out.writeBulkString(kind)
+	c.out.writeBulkString(name)
+	c.out.writeInteger(int64(count))
+}
+
+// ssubscribeCommand handles the SSUBSCRIBE command. Shard channels hash to a
+// cluster slot like keys do. With cluster-enabled, every channel of a single
+// call must belong to the same slot so the subscription can live on the node
+// owning it; a standalone server accepts any channels.
+func ssubscribeCommand(c *client, args []string) {
+	if !sameSlot(args) {
+		c.out.writeError("CROSSSLOT Keys in request don't hash to the same slot")
+		return
+	}
+
+	for _, channel := range args {
+		subscribe(pubsubShardChannels, c.shardChannels, c, channel)
+		writeSubscriptionReply(c, "ssubscribe", channel, len(c.shardChannels))
+	}
+}
+
+// sunsubscribeCommand handles the SUNSUBSCRIBE command. Without arguments the
+// client is unsubscribed from every shard channel.
+func sunsubscribeCommand(c *client, args []string) {
+	if !sameSlot(args) {
+		c.out.writeError("CROSSSLOT Keys in request don't hash to the same slot")
+		return
+	}
+
+	unsubscribeWithReply(c, "sunsubscribe", pubsubShardChannels, c.shardChannels, args)
+}
+
+// spublishCommand handles the SPUBLISH command which delivers a message to the
+// clients subscribed to a shard channel with SSUBSCRIBE. Unlike PUBLISH, shard
+// messages are neither matched against patterns nor seen by SUBSCRIBE clients.
+func spublishCommand(c *client, args []string) {
+	channel, message := args[0], args[1]
+
+	receivers := 0
+	if subscribers, ok := pubsubShardChannels[channel]; ok {
+		for sub := range subscribers {
+			sub.out.writePush("smessage", channel, message)
+			sub.out.flush()
+			receivers++
+		}
+	}
+
+	c.out.writeInteger(int64(receivers))
+}
+
+// sameSlot reports whether every channel hashes to the same cluster slot. It
+// is always true unless cluster-enabled is set, like on a standalone Redis.
+func sameSlot(channels []string) bool {
+	if !config.clusterEnabled {
+		return true
+	}
+	for _, channel := range channels[min(1, len(channels)):] {
+		if keyHashSlot(channel) != keyHashSlot(channels[0]) {
+			return false
+		}
+	}
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -60,7 +61,6 @@ func loadRDBFile() error {
 			if err := parseDatabase(file); err != nil {
 				return fmt.Errorf("error parsing database: %w", err)
 			}
-			return nil
 		case 0xFF:
 			fmt.Println("DEBUG: Found end of RDB file marker (0xFF)")
 			return nil
@@ -95,17 +95,19 @@ func parseMetadata(file io.Reader) error {
 	return nil
 }
 
-// parseDatabase processes the database section of an RDB file, loading key-value pairs
-// into memory storage. It handles both regular and expired keys.
+// parseDatabase processes one database section of an RDB file, loading its key-value
+// pairs into the logical database selected by the section header. It handles both
+// regular and expired keys.
 //
 // Parameters:
-//   - file: The RDB file reader positioned at the start of a database section
+//   - file: The RDB file reader positioned right after the 0xFE database selector marker
 //
 // Returns:
-//   - error: If any parsing or I/O error occurs
+//   - error: If any parsing or I/O error occurs, or the database index is out of range
 //
 // Behavior:
-//   - Reads key-value pairs until end of database section
+//   - Reads key-value pairs until the next database section (0xFE) or the end of
+//     the file (0xFF), leaving the reader positioned on that marker
 //   - Handles expiration timestamps (seconds and milliseconds precision)
 //   - Only stores keys that are not expired
 //   - Supports string values (type 0)
@@ -113,48 +115,47 @@ func parseMetadata(file io.Reader) error {
 //
 // Example:
 //
-//	For an RDB file containing:
+//	For an RDB file containing, in database 1:
 //	  - Key "foo" with value "bar"
 //	  - Key "exp" with value "soon" and expiration time
-//	It will load "foo" into storage and skip "exp" if expired
+//	It will load "foo" into database 1 and skip "exp" if expired
 func parseDatabase(file io.ReadSeeker) error {
-	// Step 1: Read database selector (0xFE 0x00)
+	// Step 1: Read database selector (0xFE <index>)
 	// This indicates the start of a new database in the RDB file
-	_, err := readSizeEncoded(file)
+	index, err := readSizeEncoded(file)
 	if err != nil {
 		return fmt.Errorf("failed to read database selector: %w", err)
 	}
 
-	// Step 2: Handle stream dictionary (0xFB 0x01)
-	// This indicates the presence of a stream dictionary in the RDB file
+	db, ok := getDatabase(int(index))
+	if !ok {
+		return fmt.Errorf("database index %d out of range (databases: %d)", index, len(databases))
+	}
+	fmt.Printf("DEBUG: Loading keys into database %d\n", index)
+
+	// Step 2: Handle resize info (0xFB <hash table size> <expires table size>)
+	// These are only sizing hints, so they are read and ignored
 	b, err := readByte(file)
 	if err != nil {
-		return fmt.Errorf("failed to read stream dictionary marker: %w", err)
+		return fmt.Errorf("failed to read resize info marker: %w", err)
 	}
 	if b == 0xFB {
-		// Consume the 0x01 byte
-		_, err = readByte(file)
-		if err != nil {
-			return fmt.Errorf("failed to read stream dictionary value byte: %w", err)
-		}
-
-		// Read the size of the stream dictionary
-		streamDictSize, err := readSizeEncoded(file)
+		hashTableSize, err := readSizeEncoded(file)
 		if err != nil {
-			return fmt.Errorf("failed to read stream dictionary size: %w", err)
+			return fmt.Errorf("failed to read hash table size: %w", err)
 		}
 
-		// Skip over the stream dictionary data
-		_, err = file.Seek(int64(streamDictSize), io.SeekCurrent)
+		expiresTableSize, err := readSizeEncoded(file)
 		if err != nil {
-			return fmt.Errorf("failed to skip stream dictionary data: %w", err)
+			return fmt.Errorf("failed to read expires table size: %w", err)
 		}
 
-		fmt.Printf("DEBUG: Skipped stream dictionary of size: %d bytes\n", streamDictSize)
+		fmt.Printf("DEBUG: Database %d has %d keys, %d with an expiry\n",
+			index, hashTableSize, expiresTableSize)
 	} else {
 		// If it wasn't 0xFB, put the byte back for the next stage
 		if _, err := file.Seek(-1, io.SeekCurrent); err != nil {
-			return fmt.Errorf("failed to seek back after stream dictionary check: %w", err)
+			return fmt.Errorf("failed to seek back after resize info check: %w", err)
 		}
 	}
 
@@ -203,8 +204,12 @@ func parseDatabase(file io.ReadSeeker) error {
 				return fmt.Errorf("failed to read value type after expiration: %w", err)
 			}
 
-		case 0xFF: // End of RDB file
-			fmt.Println("DEBUG: Reached end of RDB file")
+		case 0xFE, 0xFF: // Next database section or end of RDB file
+			// Put the marker back so the caller can handle it
+			if _, err := file.Seek(-1, io.SeekCurrent); err != nil {
+				return fmt.Errorf("failed to seek back to section marker: %w", err)
+			}
+			fmt.Printf("DEBUG: Finished loading database %d\n", index)
 			return nil
 
 		case 0xEF, 0x12, 0x8A, 0xC7, 0x28:
@@ -258,7 +263,7 @@ func parseDatabase(file io.ReadSeeker) error {
 
 		// Step 6: Store in memory (only if not expired)
 		if expiresAt.IsZero() || time.Now().Before(expiresAt) {
-			storage.Store(key, &storedValue{
+			db.setKey(key, &storedValue{
 				value:     value,
 				expiresAt: expiresAt,
 			})
@@ -267,3 +272,90 @@ func parseDatabase(file io.ReadSeeker) error {
 		}
 	}
 }
+
+// saveRDBFile writes every database to the RDB file in config.dir, in the
+// format loadRDBFile reads: the header, auxiliary fields, one section per
+// non-empty database with its resize info, then the end marker. The checksum is
+// written as zero, which Redis reads as "checksum disabled". The file is
+// replaced atomically, so a failed save leaves the previous snapshot intact.
+// The caller must hold commandLock so no command changes the keys meanwhile.
+func saveRDBFile() error {
+	var b bytes.Buffer
+	b.WriteString("REDIS0011")
+	writeRDBAux(&b, "redis-ver", redisVersion)
+	writeRDBAux(&b, "redis-bits", "64")
+	writeRDBAux(&b, "ctime", strconv.FormatInt(time.Now().Unix(), 10))
+
+	now := time.Now()
+	for _, db := range databases {
+		keys := map[string]*storedValue{}
+		expires := 0
+		db.storage().Range(func(key, value interface{}) bool {
+			if sv, ok := value.(*storedValue); ok && !sv.isExpired(now) {
+				keys[key.(string)] = sv
+				if !sv.expiresAt.IsZero() {
+					expires++
+				}
+			}
+			return true
+		})
+		if len(keys) == 0 {
+			continue
+		}
+
+		b.WriteByte(0xFE)
+		writeRDBSize(&b, uint32(db.id))
+		b.WriteByte(0xFB)
+		writeRDBSize(&b, uint32(len(keys)))
+		writeRDBSize(&b, uint32(expires))
+
+		for key, sv := range keys {
+			if !sv.expiresAt.IsZero() {
+				b.WriteByte(0xFC)
+				b.Write(binary.LittleEndian.AppendUint64(nil, uint64(sv.expiresAt.UnixMilli())))
+			}
+			b.WriteByte(0x00) // String value type
+			writeRDBString(&b, key)
+			writeRDBString(&b, sv.value)
+		}
+	}
+
+	b.WriteByte(0xFF)
+	b.Write(make([]byte, 8))
+
+	path := filepath.Join(config.dir, config.dbFilename)
+	fmt.Printf("DEBUG: Saving RDB file to path: %s\n", path)
+	return writeFileAtomic(path, b.String())
+}
+
+// writeRDBAux writes an auxiliary field (0xFA), read back by parseMetadata.
+func writeRDBAux(b *bytes.Buffer, name, value string) {
+	b.WriteByte(0xFA)
+	writeRDBString(b, name)
+	writeRDBString(b, value)
+}
+
+// writeRDBSize writes n with the size encoding readSizeEncoded decodes.
+//
+// Example:
+//
+//	Input: 700
+//	Output: 0x42 0xBC
+func writeRDBSize(b *bytes.Buffer, n uint32) {
+	switch {
+	case n < 1<<6:
+		b.WriteByte(byte(n))
+	case n < 1<<14:
+		b.WriteByte(0x40 | byte(n>>8))
+		b.WriteByte(byte(n))
+	default:
+		b.WriteByte(0x80)
+		b.Write(binary.BigEndian.AppendUint32(nil, n))
+	}
+}
+
+// writeRDBString writes s as a length-prefixed string.
+func writeRDBString(b *bytes.Buffer, s string) {
+	writeRDBSize(b, uint32(len(s)))
+	b.WriteString(s)
+}
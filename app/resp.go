@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replyChunkSize is the amount of pending output that is handed to the writer
+// goroutine without waiting for flush, so large replies stream out while they
+// are being produced.
+const replyChunkSize = 16 * 1024
+
+// errOutputBufferLimit is the write error of a client disconnected for going
+// over its client-output-buffer-limit.
+var errOutputBufferLimit = errors.New("output buffer limit reached")
+
+// replyWriter encodes RESP replies straight into the output buffer of a
+// connection, so handlers never build reply strings. A writer goroutine sends
+// the buffer to the connection when flush is called or once it holds
+// replyChunkSize bytes, so neither the connection's own goroutine nor the
+// clients publishing to it ever block on a slow socket. The buffer size is
+// checked against client-output-buffer-limit after every write.
+//
+// The shape of some replies depends on the protocol version negotiated with
+// HELLO: RESP3 has native types for nulls, maps, sets, doubles, booleans, big
+// numbers, verbatim strings and push frames, which RESP2 clients receive as the
+// closest RESP2 type.
+// Protocol reference: https://redis.io/docs/reference/protocol-spec/
+//
+// Arrays whose length is only known once their elements are written, like the
+// reply of KEYS, are started with writeDeferredArrayLen: the elements are
+// encoded as they are produced and the header is inserted in front of them by
+// setDeferredArrayLen, like Redis's addReplyDeferredLen.
+//
+// Every method is safe for concurrent use, so other connections can push
+// pub/sub messages while the owner writes its replies.
+type replyWriter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond // Wakes up the writer goroutine, uses mu
+	w     io.Writer
+	proto int   // RESP protocol version (2 or 3)
+	err   error // First write error, later writes are dropped
+	buf   []byte
+
+	pending   []byte // Replies not handed to the writer goroutine yet
+	spare     []byte // Buffer reused for pending once the writer is done with it
+	sending   int    // Bytes being written by the writer goroutine
+	flushing  bool   // flush was called since the last hand-over
+	deferred  int    // Offset in pending of the open deferred array length, -1 if none
+	held      []byte // Push frames written while a deferred length is open
+	closed    bool   // close was called, the writer exits once pending is sent
+	done      chan struct{}
+	softSince time.Time // When the buffer went over the soft limit, zero if below
+
+	class      func() outputBufferClass // Limit class of the client, see outputBufferLimitFor
+	onOverflow func()                   // Called, with mu held, when a limit is reached
+}
+
+// newReplyWriter creates the output of a connection and starts its writer
+// goroutine. class reports which client-output-buffer-limit applies to the
+// connection and onOverflow disconnects it once that limit is reached.
+func newReplyWriter(w io.Writer, class func() outputBufferClass, onOverflow func()) *replyWriter {
+	r := &replyWriter{
+		w:          w,
+		proto:      2,
+		deferred:   -1,
+		done:       make(chan struct{}),
+		class:      class,
+		onOverflow: onOverflow,
+	}
+	r.cond = sync.NewCond(&r.mu)
+
+	go r.writeLoop()
+	return r
+}
+
+// protocol returns the RESP protocol version replies are encoded for.
+func (r *replyWriter) protocol() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.proto
+}
+
+// setProto switches the encoding of the following replies to RESP version proto.
+func (r *replyWriter) setProto(proto int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.proto = proto
+}
+
+// writeSimpleString writes "+<s>\r\n". s must not contain CR or LF.
+func (r *replyWriter) writeSimpleString(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLine('+', s)
+}
+
+// writeError writes "-<msg>\r\n". msg starts with the error code, e.g.
+// "ERR syntax error" or "WRONGTYPE Operation against a key holding the wrong kind of value".
+func (r *replyWriter) writeError(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLine('-', msg)
+}
+
+// writeInteger writes ":<n>\r\n".
+func (r *replyWriter) writeInteger(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putInt(':', n)
+}
+
+// writeBulkString writes "$<len>\r\n<s>\r\n".
+func (r *replyWriter) writeBulkString(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putBulk(s)
+}
+
+// writeNull writes a null reply: "_" in RESP3, a null bulk string in RESP2.
+func (r *replyWriter) writeNull() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putString("_\r\n")
+	} else {
+		r.putString("$-1\r\n")
+	}
+}
+
+// writeNullArray writes a null array: "_" in RESP3, "*-1" in RESP2.
+func (r *replyWriter) writeNullArray() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putString("_\r\n")
+	} else {
+		r.putString("*-1\r\n")
+	}
+}
+
+// writeArrayLen starts an array of n elements, which must be written next.
+func (r *replyWriter) writeArrayLen(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putInt('*', int64(n))
+}
+
+// writeDeferredArrayLen starts an array whose length is not known yet. Its
+// elements are written next and setDeferredArrayLen must be called after the
+// last one. Only one deferred length can be open at a time, and the output
+// that follows it is held back from the connection until it is set.
+func (r *replyWriter) writeDeferredArrayLen() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.deferred = len(r.pending)
+	}
+}
+
+// setDeferredArrayLen sets the length of the array started by
+// writeDeferredArrayLen to n, the number of elements written since.
+func (r *replyWriter) setDeferredArrayLen(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at := r.deferred
+	r.deferred = -1
+	if r.err != nil || at < 0 {
+		return
+	}
+
+	r.buf = append(r.buf[:0], '*')
+	r.buf = strconv.AppendInt(r.buf, int64(n), 10)
+	r.buf = append(r.buf, '\r', '\n')
+
+	end := len(r.pending)
+	r.pending = append(r.pending, r.buf...)
+	copy(r.pending[at+len(r.buf):], r.pending[at:end])
+	copy(r.pending[at:], r.buf)
+	r.pending = append(r.pending, r.held...)
+	r.held = r.held[:0]
+	r.grown()
+}
+
+// writeMapLen starts a map of n key/value pairs, which must be written next as
+// 2*n elements. RESP2 clients receive a flat array.
+func (r *replyWriter) writeMapLen(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putInt('%', int64(n))
+	} else {
+		r.putInt('*', int64(2*n))
+	}
+}
+
+// writeSetLen starts a set of n elements, sent as an array to RESP2 clients.
+func (r *replyWriter) writeSetLen(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putInt('~', int64(n))
+	} else {
+		r.putInt('*', int64(n))
+	}
+}
+
+// writePushLen starts an out-of-band push frame of n elements (pub/sub
+// messages), sent as an array to RESP2 clients.
+func (r *replyWriter) writePushLen(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putPushLen(n)
+}
+
+// writeDouble writes f as a double, sent as a bulk string to RESP2 clients.
+func (r *replyWriter) writeDouble(f float64) {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putLine(',', s)
+	} else {
+		r.putBulk(s)
+	}
+}
+
+// writeBool writes b as a boolean, sent as the integer 1 or 0 to RESP2 clients.
+func (r *replyWriter) writeBool(b bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.proto >= 3 && b:
+		r.putString("#t\r\n")
+	case r.proto >= 3:
+		r.putString("#f\r\n")
+	case b:
+		r.putString(":1\r\n")
+	default:
+		r.putString(":0\r\n")
+	}
+}
+
+// writeBigNumber writes the decimal integer n, which may not fit in 64 bits, as a
+// big number, sent as a bulk string to RESP2 clients.
+func (r *replyWriter) writeBigNumber(n string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto >= 3 {
+		r.putLine('(', n)
+	} else {
+		r.putBulk(n)
+	}
+}
+
+// writeVerbatim writes s as a verbatim string with a three-letter format such as
+// "txt" or "mkd", sent as a plain bulk string to RESP2 clients.
+func (r *replyWriter) writeVerbatim(format, s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.proto < 3 {
+		r.putBulk(s)
+		return
+	}
+
+	r.putInt('=', int64(len(s)+4))
+	r.putString(format)
+	r.putString(":")
+	r.putString(s)
+	r.putString("\r\n")
+}
+
+// writeBulkStrings writes values as an array of bulk strings.
+func (r *replyWriter) writeBulkStrings(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putInt('*', int64(len(values)))
+	for _, v := range values {
+		r.putBulk(v)
+	}
+}
+
+// writePush writes values as a push frame of bulk strings. While a deferred
+// array is open, the frame is held back and written after the array, so a
+// message published meanwhile does not end up among its elements.
+func (r *replyWriter) writePush(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := len(r.pending)
+	r.putPushLen(len(values))
+	for _, v := range values {
+		r.putBulk(v)
+	}
+
+	if r.deferred >= 0 && r.err == nil {
+		r.held = append(r.held, r.pending[start:]...)
+		r.pending = r.pending[:start]
+	}
+}
+
+// flush hands the buffered replies over to the writer goroutine, which sends
+// them to the connection.
+//
+// Returns:
+//   - The first error that occurred while writing, if any
+func (r *replyWriter) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.flushing = true
+		r.cond.Signal()
+	}
+	return r.err
+}
+
+// close sends the buffered replies and stops the writer goroutine. It returns
+// once the writer is done, so the connection can be closed without losing the
+// last reply, e.g. the +OK of QUIT.
+func (r *replyWriter) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.deferred = -1
+	r.pending = append(r.pending, r.held...)
+	r.held = nil
+	r.cond.Signal()
+	r.mu.Unlock()
+
+	<-r.done
+}
+
+// size returns the amount of output waiting to be sent to the client, which is
+// what client-output-buffer-limit applies to.
+func (r *replyWriter) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending) + r.sending
+}
+
+// writeLoop sends the pending replies to the connection, one batch at a time,
+// until close is called or a write fails.
+func (r *replyWriter) writeLoop() {
+	defer close(r.done)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		for r.err == nil && !r.readyToSend() {
+			if r.closed && len(r.pending) == 0 {
+				return
+			}
+			r.cond.Wait()
+		}
+		if r.err != nil {
+			return
+		}
+
+		// Output after an open deferred length stays pending until the
+		// length is set in front of it.
+		n := r.sendable()
+		data := r.pending[:n]
+		r.pending = append(r.spare[:0], r.pending[n:]...)
+		r.spare = nil
+		r.flushing = false
+		r.sending = len(data)
+		if r.deferred >= 0 {
+			r.deferred -= n
+		}
+
+		r.mu.Unlock()
+		_, err := r.w.Write(data)
+		r.mu.Lock()
+
+		r.sending = 0
+		if cap(data) <= 4*replyChunkSize {
+			r.spare = data[:0]
+		}
+		if err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+}
+
+func (r *replyWriter) readyToSend() bool {
+	n := r.sendable()
+	return n > 0 && (r.flushing || r.closed || n >= replyChunkSize)
+}
+
+// sendable returns how much of pending can be sent: everything before the
+// open deferred length, if any.
+func (r *replyWriter) sendable() int {
+	if r.deferred >= 0 {
+		return r.deferred
+	}
+	return len(r.pending)
+}
+
+// The put* helpers below must be called with mu held.
+
+func (r *replyWriter) putString(s string) {
+	if r.err != nil {
+		return
+	}
+	r.pending = append(r.pending, s...)
+	r.grown()
+}
+
+func (r *replyWriter) putLine(prefix byte, s string) {
+	r.buf = append(r.buf[:0], prefix)
+	r.buf = append(r.buf, s...)
+	r.buf = append(r.buf, '\r', '\n')
+	r.putBytes(r.buf)
+}
+
+func (r *replyWriter) putInt(prefix byte, n int64) {
+	r.buf = append(r.buf[:0], prefix)
+	r.buf = strconv.AppendInt(r.buf, n, 10)
+	r.buf = append(r.buf, '\r', '\n')
+	r.putBytes(r.buf)
+}
+
+func (r *replyWriter) putBulk(s string) {
+	r.putInt('$', int64(len(s)))
+	r.putString(s)
+	r.putString("\r\n")
+}
+
+func (r *replyWriter) putPushLen(n int) {
+	if r.proto >= 3 {
+		r.putInt('>', int64(n))
+	} else {
+		r.putInt('*', int64(n))
+	}
+}
+
+func (r *replyWriter) putBytes(b []byte) {
+	if r.err != nil {
+		return
+	}
+	r.pending = append(r.pending, b...)
+	r.grown()
+}
+
+// grown wakes up the writer goroutine once a chunk of output is pending, and
+// drops the client if its output buffer went over its limit.
+func (r *replyWriter) grown() {
+	if len(r.pending) >= replyChunkSize {
+		r.cond.Signal()
+	}
+
+	if r.class == nil {
+		return
+	}
+	limit := outputBufferLimitFor(r.class())
+	if !limit.reached(int64(len(r.pending)+r.sending), &r.softSince) {
+		return
+	}
+
+	r.err = errOutputBufferLimit
+	r.pending = nil
+	r.deferred = -1
+	r.held = nil
+	r.cond.Signal()
+	if r.onOverflow != nil {
+		r.onOverflow()
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 10
+
+// scanOptions holds the optional arguments shared by SCAN, HSCAN, SSCAN and ZSCAN.
+type scanOptions struct {
+	pattern  string // MATCH pattern, empty when not given
+	count    int    // COUNT hint, how many entries to inspect per call
+	typeName string // TYPE filter (SCAN only), empty when not given
+}
+
+// parseScanOptions parses "[MATCH pattern] [COUNT count] [TYPE type]" starting at args[0].
+//
+// Returns:
+//   - The parsed options
+//   - errorMessage: error reply message if validation fails
+func parseScanOptions(args []string, allowType bool) (opts scanOptions, errorMessage string) {
+	opts.count = defaultScanCount
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, "ERR syntax error"
+		}
+
+		value := args[i+1]
+		switch option := strings.ToUpper(args[i]); {
+		case option == "MATCH":
+			opts.pattern = value
+		case option == "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			if count < 1 {
+				return opts, "ERR syntax error"
+			}
+			opts.count = count
+		case option == "TYPE" && allowType:
+			opts.typeName = strings.ToLower(value)
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	// "*" matches everything, skip the matcher altogether.
+	if opts.pattern == "*" {
+		opts.pattern = ""
+	}
+
+	return opts, ""
+}
+
+// parseScanCursor parses the cursor argument of the SCAN family.
+func parseScanCursor(s string) (uint64, bool) {
+	cursor, err := strconv.ParseUint(s, 10, 64)
+	return cursor, err == nil
+}
+
+// scanHash returns the hash that places key in a scanTable bucket.
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// scanTableMinBuckets is the smallest number of buckets of a scanTable.
+const scanTableMinBuckets = 4
+
+// scanTable groups the keys of a database into a power-of-two number of buckets
+// by scanHash, so SCAN can resume from a bucket instead of walking every key.
+// It grows when it holds more keys than buckets and shrinks when it is less
+// than an eighth full. Like Redis's dict, a resize is incremental: the new
+// buckets live in tables[1] and every add or remove moves one bucket of
+// tables[0] over, so no single command rehashes the whole keyspace. It is
+// guarded by commandLock.
+type scanTable struct {
+	tables    [2][][]string // tables[1] is only used while rehashing
+	rehashIdx int           // Next bucket of tables[0] to move, -1 when not rehashing
+	size      int
+}
+
+func newScanTable() *scanTable {
+	return &scanTable{tables: [2][][]string{make([][]string, scanTableMinBuckets)}, rehashIdx: -1}
+}
+
+func (t *scanTable) rehashing() bool {
+	return t.rehashIdx != -1
+}
+
+// add inserts key, which must not be in the table yet.
+func (t *scanTable) add(key string) {
+	t.rehashStep()
+
+	table := 0
+	if t.rehashing() {
+		table = 1
+	}
+	i := scanHash(key) & uint64(len(t.tables[table])-1)
+	t.tables[table][i] = append(t.tables[table][i], key)
+	t.size++
+
+	if !t.rehashing() && t.size > len(t.tables[0]) {
+		t.startResize(len(t.tables[0]) * 2)
+	}
+}
+
+// remove deletes key if it is in the table.
+func (t *scanTable) remove(key string) {
+	t.rehashStep()
+
+	h := scanHash(key)
+	for table := 0; table < 2 && t.tables[table] != nil; table++ {
+		i := h & uint64(len(t.tables[table])-1)
+		bucket := t.tables[table][i]
+		for j, k := range bucket {
+			if k != key {
+				continue
+			}
+			bucket[j] = bucket[len(bucket)-1]
+			bucket[len(bucket)-1] = ""
+			t.tables[table][i] = bucket[:len(bucket)-1]
+			t.size--
+
+			if !t.rehashing() && len(t.tables[0]) > scanTableMinBuckets && t.size < len(t.tables[0])/8 {
+				t.startResize(len(t.tables[0]) / 2)
+			}
+			return
+		}
+	}
+}
+
+// startResize allocates the n buckets the keys are moved to by rehashStep.
+func (t *scanTable) startResize(n int) {
+	t.tables[1] = make([][]string, n)
+	t.rehashIdx = 0
+}
+
+// rehashStep moves the next non-empty bucket of tables[0] to tables[1],
+// visiting at most 10 empty buckets, and swaps the tables in once every bucket
+// was moved. It does nothing when the table is not rehashing.
+func (t *scanTable) rehashStep() {
+	if !t.rehashing() {
+		return
+	}
+
+	emptyVisits := 10
+	for t.rehashIdx < len(t.tables[0]) && len(t.tables[0][t.rehashIdx]) == 0 && emptyVisits > 0 {
+		t.rehashIdx++
+		emptyVisits--
+	}
+	if t.rehashIdx < len(t.tables[0]) && emptyVisits > 0 {
+		mask := uint64(len(t.tables[1]) - 1)
+		for _, key := range t.tables[0][t.rehashIdx] {
+			i := scanHash(key) & mask
+			t.tables[1][i] = append(t.tables[1][i], key)
+		}
+		t.tables[0][t.rehashIdx] = nil
+		t.rehashIdx++
+	}
+
+	if t.rehashIdx == len(t.tables[0]) {
+		t.tables[0], t.tables[1] = t.tables[1], nil
+		t.rehashIdx = -1
+	}
+}
+
+// randomKey returns a random key of the table, picked like Redis's
+// dictGetRandomKey: a random non-empty bucket, then a random key in it. The
+// table is kept at least an eighth full, so few buckets are tried.
+//
+// Returns:
+//   - The key and true, or "" and false if the table is empty
+func (t *scanTable) randomKey() (string, bool) {
+	if t.size == 0 {
+		return "", false
+	}
+
+	for {
+		var bucket []string
+		if t.rehashing() {
+			// The buckets of tables[0] before rehashIdx were moved and are empty.
+			n0 := len(t.tables[0])
+			i := t.rehashIdx + rand.Intn(n0+len(t.tables[1])-t.rehashIdx)
+			if i < n0 {
+				bucket = t.tables[0][i]
+			} else {
+				bucket = t.tables[1][i-n0]
+			}
+		} else {
+			bucket = t.tables[0][rand.Intn(len(t.tables[0]))]
+		}
+
+		if len(bucket) > 0 {
+			return bucket[rand.Intn(len(bucket))], true
+		}
+	}
+}
+
+// scan returns the keys of the buckets visited from cursor until at least
+// count keys were collected, along with the cursor of the next call, 0 once
+// every bucket was visited. Like Redis's dictScan, the cursor is a bucket index
+// incremented from its most significant bit: the buckets of a smaller or larger
+// table map to contiguous cursor ranges, so a key present for the whole
+// iteration is returned at least once even if the table is resized between
+// calls. At most 10*count empty cursor positions are visited per call.
+//
+// Example:
+//
+//	Input: cursor 0, count 1, 4 buckets holding [], ["b"], [], ["a", "c"]
+//	Output: ["b"], cursor 3 (buckets are visited in the order 0, 2, 1, 3)
+func (t *scanTable) scan(cursor uint64, count int) (keys []string, next uint64) {
+	if t.size == 0 {
+		return nil, 0
+	}
+
+	emptyVisits := count * 10
+	for {
+		before := len(keys)
+		keys, cursor = t.scanBuckets(keys, cursor)
+		if len(keys) == before {
+			emptyVisits--
+		}
+
+		if cursor == 0 || len(keys) >= count || emptyVisits <= 0 {
+			return keys, cursor
+		}
+	}
+}
+
+// scanBuckets appends the keys of the buckets at cursor to keys and returns the
+// cursor that follows. While rehashing, that is the bucket of the smaller table
+// and every bucket of the larger one it expands to, as in dictScan.
+func (t *scanTable) scanBuckets(keys []string, cursor uint64) ([]string, uint64) {
+	small := t.tables[0]
+	if !t.rehashing() {
+		mask := uint64(len(small) - 1)
+		return append(keys, small[cursor&mask]...), nextScanCursor(cursor, mask)
+	}
+
+	large := t.tables[1]
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	m0, m1 := uint64(len(small)-1), uint64(len(large)-1)
+
+	keys = append(keys, small[cursor&m0]...)
+	for {
+		keys = append(keys, large[cursor&m1]...)
+		cursor = nextScanCursor(cursor, m1)
+		if cursor&(m0^m1) == 0 {
+			return keys, cursor
+		}
+	}
+}
+
+// nextScanCursor increments the bits of cursor covered by mask, starting from
+// the most significant one.
+func nextScanCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	return bits.Reverse64(bits.Reverse64(cursor) + 1)
+}
+
+// scanCommand handles the SCAN command: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// The cursor is stateless: it is the next bucket of the database's scanTable to
+// visit, see scanTable.scan. Every key present for the whole iteration is
+// returned at least once, no matter how many keys are added or removed between
+// calls; keys may be returned more than once. Each call costs about COUNT keys,
+// however large the database is.
+//
+// Returns:
+//   - "*2\r\n$<len>\r\n<next cursor>\r\n*<n>\r\n<keys...>", where a next cursor of
+//     "0" means the iteration is complete
+//
+// Example:
+//
+//	Input: ["0", "MATCH", "user:*", "COUNT", "100"]
+//	Output: "*2\r\n$1\r\n0\r\n*1\r\n$6\r\nuser:1\r\n" (if "user:1" is the only match)
+func scanCommand(c *client, args []string) {
+	cursor, ok := parseScanCursor(args[0])
+	if !ok {
+		c.out.writeError("ERR invalid cursor")
+		return
+	}
+
+	opts, errMsg := parseScanOptions(args[1:], true)
+	if errMsg != "" {
+		c.out.writeError(errMsg)
+		return
+	}
+
+	// The bucket keys are copied by scan, so lookupKey may remove expired
+	// keys from the table while they are filtered.
+	candidates, nextCursor := c.db.keys.Load().scan.scan(cursor, opts.count)
+
+	c.out.writeArrayLen(2)
+	c.out.writeBulkString(strconv.FormatUint(nextCursor, 10))
+	c.out.writeDeferredArrayLen()
+	count := 0
+	for _, key := range candidates {
+		if opts.pattern != "" && !stringMatch(opts.pattern, key, false) {
+			continue
+		}
+		sv, ok := c.db.lookupKey(key)
+		if !ok {
+			continue
+		}
+		if opts.typeName != "" && sv.typeName() != opts.typeName {
+			continue
+		}
+		c.out.writeBulkString(key)
+		count++
+	}
+	c.out.setDeferredArrayLen(count)
+}
+
+// collectionScanCommand builds the handler of HSCAN, SSCAN and ZSCAN:
+// <command> key cursor [MATCH pattern] [COUNT count]
+//
+// The keyspace only holds strings for now, so a missing key is reported as an
+// empty, completed scan and an existing key as a WRONGTYPE error, as Redis does.
+func collectionScanCommand(typeName string) CommandHandler {
+	return func(c *client, args []string) {
+		if _, ok := parseScanCursor(args[1]); !ok {
+			c.out.writeError("ERR invalid cursor")
+			return
+		}
+
+		if _, errMsg := parseScanOptions(args[2:], false); errMsg != "" {
+			c.out.writeError(errMsg)
+			return
+		}
+
+		sv, ok := c.db.lookupKey(args[0])
+		if !ok {
+			writeScanReply(c, 0, nil)
+			return
+		}
+		if sv.typeName() != typeName {
+			c.out.writeError("WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+
+		writeScanReply(c, 0, nil)
+	}
+}
+
+// writeScanReply writes the two-element reply shared by the SCAN family: the
+// next cursor and the elements of this iteration.
+func writeScanReply(c *client, cursor uint64, elements []string) {
+	c.out.writeArrayLen(2)
+	c.out.writeBulkString(strconv.FormatUint(cursor, 10))
+	c.out.writeBulkStrings(elements...)
+}
+
+// typeCommand handles the TYPE command which returns the type of the value stored
+// at a key, or "none" if the key does not exist.
+func typeCommand(c *client, args []string) {
+	sv, ok := c.db.lookupKey(args[0])
+	if !ok {
+		c.out.writeSimpleString("none")
+		return
+	}
+
+	c.out.writeSimpleString(sv.typeName())
+}
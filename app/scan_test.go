@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestScanTableReturnsStableKeys checks the SCAN guarantee: every key present
+// for the whole iteration is returned at least once, even when keys are added
+// or removed between calls and the table grows or shrinks, including while it
+// is rehashing.
+func TestScanTableReturnsStableKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial int // Keys present before the first call, the first stable ones
+		stable  int // How many of them stay for the whole iteration
+		between func(t *scanTable, call int)
+	}{
+		{
+			name:    "no changes",
+			initial: 1000,
+			stable:  1000,
+		},
+		{
+			name:    "grows",
+			initial: 100,
+			stable:  100,
+			between: func(t *scanTable, call int) {
+				for i := 0; i < 200 && call < 20; i++ {
+					t.add(fmt.Sprintf("new:%d:%d", call, i))
+				}
+			},
+		},
+		{
+			name:    "shrinks",
+			initial: 5000,
+			stable:  50,
+			between: func(t *scanTable, call int) {
+				for i := 0; i < 300; i++ {
+					t.remove(fmt.Sprintf("key:%d", 50+call*300+i))
+				}
+			},
+		},
+		{
+			name:    "grows then shrinks",
+			initial: 64,
+			stable:  64,
+			between: func(t *scanTable, call int) {
+				for i := 0; i < 100; i++ {
+					if call < 20 {
+						t.add(fmt.Sprintf("new:%d:%d", call, i))
+					} else {
+						t.remove(fmt.Sprintf("new:%d:%d", call-20, i))
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newScanTable()
+			for i := 0; i < tt.initial; i++ {
+				table.add(fmt.Sprintf("key:%d", i))
+			}
+
+			seen := map[string]bool{}
+			var cursor uint64
+			for call := 0; ; call++ {
+				var keys []string
+				keys, cursor = table.scan(cursor, 10)
+				for _, key := range keys {
+					seen[key] = true
+				}
+				if cursor == 0 {
+					break
+				}
+				if call > 10000 {
+					t.Fatal("the scan did not complete")
+				}
+				if tt.between != nil {
+					tt.between(table, call)
+				}
+			}
+
+			for i := 0; i < tt.stable; i++ {
+				if key := fmt.Sprintf("key:%d", i); !seen[key] {
+					t.Errorf("%s was not returned", key)
+				}
+			}
+		})
+	}
+}
+
+// TestScanTableRehashesIncrementally checks that a resize moves the keys over
+// several operations and ends with every key in a single table.
+func TestScanTableRehashesIncrementally(t *testing.T) {
+	table := newScanTable()
+	for i := 0; i < 1024; i++ {
+		table.add(fmt.Sprintf("key:%d", i))
+	}
+	table.add("trigger")
+	if !table.rehashing() {
+		t.Fatal("adding more keys than buckets did not start a resize")
+	}
+
+	for i := 0; table.rehashing(); i++ {
+		if i > 2048 {
+			t.Fatal("the resize did not complete")
+		}
+		table.add(fmt.Sprintf("more:%d", i))
+	}
+
+	count := 0
+	for _, bucket := range table.tables[0] {
+		count += len(bucket)
+	}
+	if count != table.size {
+		t.Errorf("the table holds %d keys, want %d", count, table.size)
+	}
+}
@@ -1,36 +1,86 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
-	"sync"
+	"strings"
 )
 
-var (
-	storage sync.Map // Thread-safe concurrent map for key-value storage
-)
+// redisVersion is the Redis version whose behavior the server follows, as
+// reported by HELLO.
+const redisVersion = "7.2.0"
 
 func main() {
-	dir := flag.String("dir", ".", "RDB file directory")
-	dbFilename := flag.String("dbfilename", "dump.rdb", "RDB filename")
-	flag.Parse()
+	recordConfigDefaults()
+
+	// Like redis-server, the configuration file comes first and the options
+	// given as flags override it.
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if err := loadConfigFile(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+
+	registerConfigFlags(flag.CommandLine)
+	args, err := groupConfigArgs(args)
+	if err != nil {
+		fmt.Println("Error parsing the command line:", err)
+		os.Exit(1)
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 0 {
+		fmt.Println("Error parsing the command line: unexpected arguments", flag.Args())
+		os.Exit(1)
+	}
 
-	initConfig(*dir, *dbFilename)
+	initDatabases(config.databases)
+
+	if config.aclfile != "" {
+		if err := loadACLFile(); err != nil {
+			fmt.Println("Error loading ACL file:", err)
+			os.Exit(1)
+		}
+	}
 
 	if err := loadRDBFile(); err != nil {
 		fmt.Println("Error loading RDB file:", err)
 	}
 
-	l, err := net.Listen("tcp", "0.0.0.0:6379")
+	go activeExpireCycle()
+
+	listeners, err := openListeners()
 	if err != nil {
-		fmt.Println("Failed to bind to port 6379")
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	serverListeners = listeners
+
+	go handleShutdownSignals()
+
+	for _, l := range listeners[1:] {
+		go acceptConnections(l)
+	}
+	acceptConnections(listeners[0])
 
+	// The listeners are closed by shutdownServer, which exits the process
+	// once the clients are disconnected.
+	select {}
+}
+
+// acceptConnections serves every connection accepted by l until l is closed.
+// TLS handshakes happen in the connection's goroutine, on its first read.
+func acceptConnections(l net.Listener) {
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			fmt.Println("Error accepting connection: ", err.Error())
 			continue
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// shutdownFlushTimeout bounds how long shutdownServer waits for the pending
+// replies of every client to be sent before closing the connections.
+const shutdownFlushTimeout = time.Second
+
+// shutdownFlags are the options of SHUTDOWN.
+type shutdownFlags int
+
+const (
+	shutdownNoSave shutdownFlags = 1 << iota // Skip the final snapshot even if persistence is configured
+	shutdownSave                             // Save a final snapshot even if persistence is not configured
+	shutdownNow                              // Do not wait for replicas to catch up
+	shutdownForce                            // Exit even if the final snapshot fails
+)
+
+// serverListeners holds the listeners opened at startup, closed by
+// shutdownServer.
+var serverListeners []net.Listener
+
+// shutdownServer stops the server:
+//   - saves a final snapshot when save points are configured or SAVE is given,
+//     unless NOSAVE is given
+//   - closes the listeners, which also removes the Unix socket file
+//   - sends the pending replies of every client and closes its connection
+//   - exits the process
+//
+// There are no replicas to wait for, so NOW changes nothing. The caller must
+// hold commandLock, so no command runs while the server stops.
+//
+// Returns:
+//   - an error if the final snapshot failed and FORCE was not given, in which
+//     case the server keeps running; otherwise it does not return
+func shutdownServer(flags shutdownFlags) error {
+	fmt.Println("DEBUG: User requested shutdown...")
+
+	if flags&shutdownNoSave == 0 && (flags&shutdownSave != 0 || len(config.save) > 0) {
+		fmt.Println("DEBUG: Saving the final RDB snapshot before exiting.")
+		if err := saveRDBFile(); err != nil {
+			if flags&shutdownForce == 0 {
+				fmt.Println("DEBUG: Error trying to save the DB, can't exit:", err)
+				return err
+			}
+			fmt.Println("DEBUG: Error trying to save the DB, exiting anyway:", err)
+		}
+	}
+
+	for _, l := range serverListeners {
+		l.Close()
+	}
+
+	var all []*client
+	deadline := time.Now().Add(shutdownFlushTimeout)
+	clients.Range(func(key, value interface{}) bool {
+		cl := value.(*client)
+		cl.conn.SetWriteDeadline(deadline)
+		all = append(all, cl)
+		return true
+	})
+	for _, cl := range all {
+		cl.out.close()
+		cl.conn.Close()
+	}
+
+	fmt.Println("DEBUG: Redis is now ready to exit, bye bye...")
+	os.Exit(0)
+	return nil
+}
+
+// shutdownCommand handles the SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+// command. On success the server exits without replying. ABORT cancels a
+// shutdown waiting for replicas, which never happens here, so it always fails.
+//
+// Example:
+//
+//	Input: ["NOSAVE", "NOW"]
+//	Output: the connection is closed and the server exits
+func shutdownCommand(c *client, args []string) {
+	var flags shutdownFlags
+	abort := false
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "NOSAVE":
+			flags |= shutdownNoSave
+		case "SAVE":
+			flags |= shutdownSave
+		case "NOW":
+			flags |= shutdownNow
+		case "FORCE":
+			flags |= shutdownForce
+		case "ABORT":
+			abort = true
+		default:
+			c.out.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	if (flags&shutdownNoSave != 0 && flags&shutdownSave != 0) || (abort && len(args) > 1) {
+		c.out.writeError("ERR syntax error")
+		return
+	}
+	if abort {
+		c.out.writeError("ERR No shutdown in progress.")
+		return
+	}
+
+	if err := shutdownServer(flags); err != nil {
+		c.out.writeError("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+}
+
+// handleShutdownSignals shuts the server down on SIGTERM or SIGINT, the way
+// SHUTDOWN without options does. If the final snapshot fails, the server keeps
+// running, like Redis.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range signals {
+		fmt.Printf("DEBUG: Received %s, scheduling shutdown...\n", sig)
+
+		commandLock.Lock()
+		if err := shutdownServer(0); err != nil {
+			fmt.Printf("DEBUG: %s received but errors trying to shut down the server, check the logs for more information\n", sig)
+		}
+		commandLock.Unlock()
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "strings"
+
+// clusterSlots is the number of hash slots the keyspace is split into in a
+// sharded deployment.
+const clusterSlots = 16384
+
+// keyHashSlot returns the hash slot of a key or shard channel: the CRC16 of the
+// key modulo 16384. If the key contains a non-empty hash tag ("{...}"), only
+// the tag is hashed, so that "user:{42}:name" and "user:{42}:email" share a slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
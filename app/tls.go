@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// newTLSConfig builds the configuration of the TLS listener from the tls-*
+// settings. The certificate and key are required; the CA certificates are used
+// to verify client certificates according to tls-auth-clients:
+//   - "yes": clients must present a certificate signed by a trusted CA
+//   - "optional": a certificate is verified if the client presents one
+//   - "no": client certificates are not requested
+func newTLSConfig() (*tls.Config, error) {
+	if config.tlsCertFile == "" || config.tlsKeyFile == "" {
+		return nil, fmt.Errorf("tls-cert-file and tls-key-file are required when tls-port is set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.tlsCertFile, config.tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate and key: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch strings.ToLower(config.tlsAuthClients) {
+	case "yes":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		tlsConfig.ClientAuth = tls.NoClientCert
+		return tlsConfig, nil
+	default:
+		return nil, fmt.Errorf("tls-auth-clients must be one of yes, no or optional, got %q", config.tlsAuthClients)
+	}
+
+	if config.tlsCACertFile == "" {
+		return nil, fmt.Errorf("tls-ca-cert-file is required to verify client certificates (or set tls-auth-clients to no)")
+	}
+
+	pem, err := os.ReadFile(config.tlsCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificate found in %s", config.tlsCACertFile)
+	}
+	tlsConfig.ClientCAs = pool
+
+	return tlsConfig, nil
+}
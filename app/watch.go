@@ -0,0 +1,129 @@
+package main
+
+import "time"
+
+// watchKey identifies a key in a logical database.
+type watchKey struct {
+	db  *database
+	key string
+}
+
+// keyVersion tracks the modifications of a watched key.
+type keyVersion struct {
+	version  uint64 // Incremented on every write to the key
+	watchers int    // Number of clients watching the key
+}
+
+// watchedKey is a key watched by a client, with the version it had at WATCH time.
+type watchedKey struct {
+	watchKey
+	version uint64
+}
+
+// watchedKeys holds the modification version of every key watched by at least
+// one client. Keys nobody watches are not tracked, so writes to them cost a
+// single map lookup. Guarded by commandLock.
+var watchedKeys = map[watchKey]*keyVersion{}
+
+// signalModifiedKey records a write to key so that transactions watching it fail.
+func signalModifiedKey(db *database, key string) {
+	if v, ok := watchedKeys[watchKey{db, key}]; ok {
+		v.version++
+	}
+}
+
+// touchWatchedKeysInDB signals every watched key of db that exists in db or,
+// when given, in other. It is used when a database's content is replaced as a
+// whole by FLUSHDB, FLUSHALL or SWAPDB.
+func touchWatchedKeysInDB(db, other *database) {
+	for wk := range watchedKeys {
+		if wk.db != db {
+			continue
+		}
+
+		_, exists := db.storage().Load(wk.key)
+		if !exists && other != nil {
+			_, exists = other.storage().Load(wk.key)
+		}
+		if exists {
+			signalModifiedKey(db, wk.key)
+		}
+	}
+}
+
+// watchCommand handles the WATCH command which makes the next EXEC of the
+// connection fail if any of the given keys is modified in the meantime.
+func watchCommand(c *client, args []string) {
+	if c.hasFlag(flagMulti) {
+		c.out.writeError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+
+	for _, key := range args {
+		watchKeyForClient(c, key)
+	}
+
+	c.out.writeSimpleString("OK")
+}
+
+// unwatchCommand handles the UNWATCH command which forgets every watched key.
+func unwatchCommand(c *client, args []string) {
+	_ = args
+	unwatchAllKeys(c)
+	c.out.writeSimpleString("OK")
+}
+
+func watchKeyForClient(c *client, key string) {
+	wk := watchKey{c.db, key}
+	for _, w := range c.watched {
+		if w.watchKey == wk {
+			return // Already watched
+		}
+	}
+
+	// Expire the key first if needed, so that only an expiry happening after
+	// WATCH counts as a modification.
+	c.db.lookupKey(key)
+
+	v, ok := watchedKeys[wk]
+	if !ok {
+		v = &keyVersion{}
+		watchedKeys[wk] = v
+	}
+	v.watchers++
+
+	c.watched = append(c.watched, watchedKey{watchKey: wk, version: v.version})
+}
+
+// unwatchAllKeys forgets every key watched by c, and stops tracking keys that
+// no other client watches.
+func unwatchAllKeys(c *client) {
+	for _, w := range c.watched {
+		v := watchedKeys[w.watchKey]
+		v.watchers--
+		if v.watchers == 0 {
+			delete(watchedKeys, w.watchKey)
+		}
+	}
+
+	c.watched = nil
+}
+
+// watchedKeysModified reports whether any key watched by c was written, deleted
+// or has expired since it was watched.
+func watchedKeysModified(c *client) bool {
+	for _, w := range c.watched {
+		if watchedKeys[w.watchKey].version != w.version {
+			return true
+		}
+
+		// The key may have reached its expiry time without anyone touching it.
+		if val, ok := w.db.storage().Load(w.key); ok {
+			if sv, ok := val.(*storedValue); ok && sv.isExpired(time.Now()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}